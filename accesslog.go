@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// Logger of the access log, writing one JSON object per line without the
+// prefix of the standard logger so the lines stay machine readable
+var accessLogger = log.New(os.Stdout, "", 0)
+
+// Line of the access log
+type accessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int       `json:"bytes"`
+	Scales     int       `json:"scales"`
+	Format     string    `json:"format"`
+	DurationMs float64   `json:"durationMs"`
+}
+
+// Context key of the entry filled in by the handler
+type accessLogKey struct{}
+
+// Response writer recording the status code and the size of the body
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	n, err := rec.ResponseWriter.Write(data)
+	rec.bytes += n
+	return n, err
+}
+
+// Function to wrap a render handler so that every request is logged as a
+// JSON line and recorded in the metrics once the response is written
+func withAccessLog(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		entry := &accessLogEntry{Time: start, Method: r.Method, Path: r.URL.Path}
+		rec := &statusRecorder{ResponseWriter: w}
+		r = r.WithContext(context.WithValue(r.Context(), accessLogKey{}, entry))
+
+		next(rec, r)
+
+		entry.Status = rec.status
+		if entry.Status == 0 {
+			entry.Status = http.StatusOK
+		}
+		entry.Bytes = rec.bytes
+		// Read after the handler, which expands permalinks and aliases
+		entry.Format = r.URL.Query().Get("format")
+		if entry.Format == "" {
+			entry.Format = "png"
+		}
+		entry.DurationMs = float64(time.Since(start).Microseconds()) / 1000
+
+		observeRender(entry)
+
+		line, err := json.Marshal(entry)
+		if err != nil {
+			log.Printf("failed to encode access log entry: %v", err)
+			return
+		}
+		accessLogger.Println(string(line))
+	}
+}
+
+// Function to record the number of intensities of the request in its access
+// log entry, if the request is logged
+func logScaleCount(r *http.Request, count int) {
+	if entry, ok := r.Context().Value(accessLogKey{}).(*accessLogEntry); ok {
+		entry.Scales = count
+	}
+}
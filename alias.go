@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Renamed query parameters, mapping the old name to the current one. Old
+// names keep working but the response carries a deprecation warning.
+var paramAliases = map[string]string{
+	"scale_text": "scaleText",
+}
+
+// Function to rewrite the deprecated parameters of the request to their
+// current names. When both names are given the current one wins. The
+// returned warnings are meant for the Warning response header.
+func applyParamAliases(r *http.Request) []string {
+	query := r.URL.Query()
+
+	var warnings []string
+	for old, current := range paramAliases {
+		values, ok := query[old]
+		if !ok {
+			continue
+		}
+		if _, exists := query[current]; !exists {
+			query[current] = values
+		}
+		delete(query, old)
+		warnings = append(warnings, fmt.Sprintf(`299 - "Parameter %s is deprecated, use %s"`, old, current))
+	}
+	if len(warnings) == 0 {
+		return nil
+	}
+
+	sort.Strings(warnings)
+	r.URL.RawQuery = query.Encode()
+	return warnings
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+func TestParamAliases(t *testing.T) {
+	// Render both requests rather than serving the second from the cache
+	defer func(cache *renderCache) { renders = cache }(renders)
+	renders = newRenderCache(0)
+
+	render := func(param string) *httptest.ResponseRecorder {
+		query := url.Values{
+			"scale": {`[{"id": 13, "scale": 5}, {"id": 14, "scale": 3}]`},
+			param:   {"true"},
+		}
+		rec := httptest.NewRecorder()
+		mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+query.Encode(), nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("%s: status %d: %s", param, rec.Code, rec.Body.String())
+		}
+		return rec
+	}
+
+	old, current := render("scale_text"), render("scaleText")
+	if !bytes.Equal(old.Body.Bytes(), current.Body.Bytes()) {
+		t.Error("scale_text and scaleText renders differ")
+	}
+	if got, want := old.Header().Get("Warning"), `299 - "Parameter scale_text is deprecated, use scaleText"`; got != want {
+		t.Errorf("scale_text: Warning %q, want %q", got, want)
+	}
+	if got := current.Header().Get("Warning"); got != "" {
+		t.Errorf("scaleText: unexpected Warning %q", got)
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"math"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Function to check whether a ring crosses the antimeridian, which shows as
+// a jump of more than half the globe between neighbouring points
+func crossesAntimeridian(ring [][]float64) bool {
+	for i := 1; i < len(ring); i++ {
+		if math.Abs(ring[i][0]-ring[i-1][0]) > 180 {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to shift the western longitudes of a feature past 180°, the
+// coordinates are changed in place
+func shiftWest(feature *geojson.Feature) {
+	for _, ring := range featureRings(feature) {
+		for _, coord := range ring {
+			if coord[0] < 0 {
+				coord[0] += 360
+			}
+		}
+	}
+}
+
+// Function to make the longitudes of the features continuous across the
+// antimeridian, so that the bounds and the paths do not span the whole
+// globe. Features crossing it get their western part shifted past 180°, and
+// when the map reaches over the antimeridian the features lying west of it
+// follow, unless that widens the map. The collection is changed in place,
+// it is meant to run once when a map is loaded.
+func unwrapAntimeridian(fc *geojson.FeatureCollection) {
+	crossing := false
+	for _, feature := range fc.Features {
+		for _, ring := range featureRings(feature) {
+			if crossesAntimeridian(ring) {
+				shiftWest(feature)
+				crossing = true
+				break
+			}
+		}
+	}
+
+	// Separate features on both sides of the antimeridian, such as islands
+	// close to it, are joined when the map is narrower that way
+	span := func(shift bool) float64 {
+		minLon, maxLon := math.Inf(1), math.Inf(-1)
+		for _, feature := range fc.Features {
+			for _, ring := range featureRings(feature) {
+				for _, coord := range ring {
+					lon := coord[0]
+					if shift && lon < 0 {
+						lon += 360
+					}
+					minLon = min(minLon, lon)
+					maxLon = max(maxLon, lon)
+				}
+			}
+		}
+		return maxLon - minLon
+	}
+	if !crossing && span(false) <= 180 {
+		return
+	}
+	if span(true) < span(false) {
+		for _, feature := range fc.Features {
+			shiftWest(feature)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"image/color"
+)
+
+// Function to parse the background parameter, either a hex color (#rrggbb)
+// or "transparent", which is returned as a color with zero alpha
+func parseBackground(value string) (color.RGBA, error) {
+	if value == "transparent" {
+		return color.RGBA{}, nil
+	}
+	return hexToRGBA(value)
+}
+
+// Function to get the background as an SVG fill, empty when it is
+// transparent and nothing should be drawn
+func (opts renderOptions) backgroundFill() string {
+	bg := opts.background
+	if bg.A == 0 {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", bg.R, bg.G, bg.B)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"embed"
+	"fmt"
+	"io/fs"
+	"math"
+	"path"
+	"slices"
+	"sync"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Directory holding the GeoJSON of the base maps
+const mapsDir = "maps"
+
+// The base maps are built into the binary so that it does not depend on the
+// working directory. Other maps can still be rendered with geojsonUrl.
+//
+//go:embed maps/*.geojson
+var embeddedMaps embed.FS
+
+// Base map used when the request does not select one
+const defaultBaseMap = "japan"
+
+// Base maps that can be selected with the map parameter, by the GeoJSON file
+// in mapsDir. Every map uses the prefecture codes as feature IDs, so the same
+// scale payload works with any of them.
+var baseMapFiles = map[string]string{
+	"japan":  "japan.geojson",
+	"kanto":  "kanto.geojson",
+	"tohoku": "tohoku.geojson",
+}
+
+// GeoJSON of a base map, loaded once at startup. It is shared by all
+// requests and must be treated as read-only.
+type baseMap struct {
+	name            string
+	features        *geojson.FeatureCollection
+	emptyFeatureIDs []int // IDs of the features removed for lacking geometry
+
+	topologyOnce sync.Once
+	topology     *Topology
+}
+
+// Base maps by name, filled by loadBaseMaps before the server starts and
+// only read afterwards, so requests share it without locking
+var baseMaps = map[string]*baseMap{}
+
+// Function to load and parse the GeoJSON of every registered base map from
+// the directory of the file system
+func loadBaseMaps(fsys fs.FS, dir string) error {
+	for name, file := range baseMapFiles {
+		fc, emptyIDs, err := loadFeatures(fsys, path.Join(dir, file))
+		if err != nil {
+			return fmt.Errorf("map %s: %w", name, err)
+		}
+		baseMaps[name] = &baseMap{name: name, features: fc, emptyFeatureIDs: emptyIDs}
+	}
+	return nil
+}
+
+// Function to load and parse a GeoJSON file, returning the features along
+// with the IDs of the ones removed for lacking geometry
+func loadFeatures(fsys fs.FS, name string) (*geojson.FeatureCollection, []int, error) {
+	data, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read geojson: %w", err)
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal geojson: %w", err)
+	}
+	if err := validateFeatureIDs(fc); err != nil {
+		return nil, nil, err
+	}
+	unwrapAntimeridian(fc)
+	return fc, removeEmptyFeatures(fc), nil
+}
+
+// Function to check that every feature has an integral numeric id, which
+// the rendering reads without checking
+func validateFeatureIDs(fc *geojson.FeatureCollection) error {
+	for i, feature := range fc.Features {
+		value, found := feature.Properties["id"]
+		if !found {
+			return fmt.Errorf("feature %d (%s) has no id", i, featureLabel(feature))
+		}
+		id, ok := value.(float64)
+		if !ok || id != math.Trunc(id) {
+			return fmt.Errorf("feature %d (%s) has a non-integral id %v", i, featureLabel(feature), value)
+		}
+	}
+	return nil
+}
+
+// Function to describe a feature in errors by its name when it has one
+func featureLabel(feature *geojson.Feature) string {
+	if name := featureName(feature); name != "" {
+		return name
+	}
+	return "unnamed"
+}
+
+// Function to get the base map selected by the map parameter, reporting
+// whether it is registered
+func selectBaseMap(name string) (*baseMap, bool) {
+	if name == "" {
+		name = defaultBaseMap
+	}
+	m, ok := baseMaps[name]
+	return m, ok
+}
+
+// Function to check whether the ID belongs to a feature of the map, including
+// the ones without geometry. The regional maps accept every prefecture code
+// of the default map, so one payload still works with all of them.
+func (m *baseMap) hasFeatureID(id int) bool {
+	known := m
+	if baseMaps[m.name] == m {
+		known = baseMaps[defaultBaseMap]
+	}
+	for _, feature := range known.features.Features {
+		if featureID, ok := feature.Properties["id"].(float64); ok && int(featureID) == id {
+			return true
+		}
+	}
+	return slices.Contains(known.emptyFeatureIDs, id)
+}
+
+// Function to get the topology of the map, converting it only once since the
+// geometry never changes at runtime
+func (m *baseMap) loadTopology() *Topology {
+	m.topologyOnce.Do(func() {
+		m.topology = buildTopology(m.features, m.name)
+	})
+	return m.topology
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+
+	"golang.org/x/image/draw"
+)
+
+// Smallest byte budget accepted for maxBytes
+const minByteBudget = 1024
+
+// Returned when no attempt of the budget search fits into the budget
+var errOverBudget = errors.New("output does not fit into the byte budget")
+
+// Resolutions tried by the budget search once the full size does not fit,
+// as factors of the full size
+var budgetFactors = []float64{0.75, 0.5, 0.35, 0.25}
+
+// Qualities tried by the budget search of the lossy formats, those above the
+// quality of the request are skipped
+var budgetQualities = []int{75, 60, 45, 30, 15}
+
+// Function to scale the image down by the factor for the budget search
+func scaleForBudget(rgba *image.RGBA, factor float64) *image.RGBA {
+	if factor == 1 {
+		return rgba
+	}
+	bounds := rgba.Bounds()
+	width := int(float64(bounds.Dx())*factor + 0.5)
+	height := int(float64(bounds.Dy())*factor + 0.5)
+	scaled := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(scaled, scaled.Bounds(), rgba, bounds, draw.Src, nil)
+	return scaled
+}
+
+// Function to encode the image as PNG within maxBytes. The attempts go from
+// the best to the lowest quality: stronger compression, a palette, then
+// smaller resolutions. The first one that fits is returned.
+func encodePNGWithinBudget(ctx context.Context, rgba *image.RGBA, opts renderOptions, maxBytes int) ([]byte, error) {
+	type attempt struct {
+		factor   float64
+		paletted bool
+		level    png.CompressionLevel
+	}
+
+	var attempts []attempt
+	if !opts.indexed {
+		attempts = append(attempts,
+			attempt{1, false, png.DefaultCompression},
+			attempt{1, false, png.BestCompression})
+	}
+	attempts = append(attempts, attempt{1, true, png.BestCompression})
+	for _, factor := range budgetFactors {
+		if !opts.indexed {
+			attempts = append(attempts, attempt{factor, false, png.BestCompression})
+		}
+		attempts = append(attempts, attempt{factor, true, png.BestCompression})
+	}
+
+	scaled := map[float64]*image.RGBA{}
+	for _, a := range attempts {
+		src, ok := scaled[a.factor]
+		if !ok {
+			src = scaleForBudget(rgba, a.factor)
+			scaled[a.factor] = src
+		}
+
+		var img image.Image = src
+		if a.paletted {
+			img = toPaletted(src, basePalette(opts))
+		}
+		data, err := encodePNGLevel(ctx, img, a.level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode png: %w", err)
+		}
+		if len(data) <= maxBytes {
+			return data, nil
+		}
+	}
+	return nil, errOverBudget
+}
+
+// Function to encode the image with a lossy encoder within maxBytes. The
+// quality is lowered from the one of the request first, then the resolution,
+// starting again from the best quality at each size. The first attempt that
+// fits is returned. Lossless output only gets smaller resolutions.
+func encodeLossyWithinBudget(rgba *image.RGBA, opts renderOptions, maxBytes int, encode func(img image.Image, quality int) ([]byte, error)) ([]byte, error) {
+	qualities := []int{opts.quality}
+	if !opts.lossless {
+		for _, quality := range budgetQualities {
+			if quality < opts.quality {
+				qualities = append(qualities, quality)
+			}
+		}
+	}
+
+	for _, factor := range append([]float64{1}, budgetFactors...) {
+		img := scaleForBudget(rgba, factor)
+		for _, quality := range qualities {
+			data, err := encode(img, quality)
+			if err != nil {
+				return nil, err
+			}
+			if len(data) <= maxBytes {
+				return data, nil
+			}
+		}
+	}
+	return nil, errOverBudget
+}
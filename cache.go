@@ -0,0 +1,144 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+)
+
+// Default size of the render cache in bytes, configured with
+// RENDER_CACHE_BYTES
+const defaultRenderCacheSize = 64 << 20
+
+// Cache of the encoded renders, keyed by their ETag which already covers
+// everything that affects the output. The least recently used renders are
+// evicted once the size limit is reached.
+var renders = newRenderCache(defaultRenderCacheSize)
+
+// Successful response kept in the render cache
+type cachedRender struct {
+	key    string
+	header http.Header
+	body   []byte
+}
+
+// LRU cache of encoded renders, bounded by the total size of the bodies
+type renderCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List // Most recently used first
+	entries  map[string]*list.Element
+}
+
+// Function to create a render cache holding up to maxBytes of output, zero
+// disables it
+func newRenderCache(maxBytes int) *renderCache {
+	return &renderCache{
+		maxBytes: maxBytes,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// Function to get a render, marking it as the most recently used
+func (c *renderCache) get(key string) (*cachedRender, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(element)
+	return element.Value.(*cachedRender), true
+}
+
+// Function to add a render, evicting the least recently used ones until it
+// fits. Renders larger than the whole cache are not kept.
+func (c *renderCache) add(render *cachedRender) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(render.body) > c.maxBytes {
+		return
+	}
+	if element, ok := c.entries[render.key]; ok {
+		c.remove(element)
+	}
+	for c.size+len(render.body) > c.maxBytes {
+		c.remove(c.order.Back())
+	}
+	c.entries[render.key] = c.order.PushFront(render)
+	c.size += len(render.body)
+}
+
+// Function to drop an entry, the lock must be held
+func (c *renderCache) remove(element *list.Element) {
+	render := c.order.Remove(element).(*cachedRender)
+	delete(c.entries, render.key)
+	c.size -= len(render.body)
+}
+
+// Function to answer the request from the cache, reporting whether the
+// render was found. Renders without a key are never cached.
+func (c *renderCache) serve(w http.ResponseWriter, key string) bool {
+	if key == "" || c.maxBytes <= 0 {
+		return false
+	}
+	render, ok := c.get(key)
+	if !ok {
+		renderCacheLookups.WithLabelValues("miss").Inc()
+		return false
+	}
+	renderCacheLookups.WithLabelValues("hit").Inc()
+	for name, values := range render.header {
+		w.Header()[name] = append([]string(nil), values...)
+	}
+	w.Write(render.body)
+	return true
+}
+
+// Function to record the response written to the returned writer, which
+// store adds to the cache once the handler is done. Only successful
+// responses are kept.
+func (c *renderCache) record(w http.ResponseWriter, key string) (http.ResponseWriter, func()) {
+	if key == "" || c.maxBytes <= 0 {
+		return w, func() {}
+	}
+	rec := &cacheRecorder{ResponseWriter: w, maxBytes: c.maxBytes}
+	return rec, func() {
+		if rec.status == http.StatusOK && !rec.overflow {
+			c.add(&cachedRender{key: key, header: w.Header().Clone(), body: rec.body.Bytes()})
+		}
+	}
+}
+
+// Response writer keeping a copy of the body for the render cache
+type cacheRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	maxBytes int
+	overflow bool // The body outgrew the cache and is not kept
+}
+
+func (rec *cacheRecorder) WriteHeader(status int) {
+	if rec.status == 0 {
+		rec.status = status
+	}
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *cacheRecorder) Write(data []byte) (int, error) {
+	if rec.status == 0 {
+		rec.status = http.StatusOK
+	}
+	if !rec.overflow && rec.status == http.StatusOK {
+		rec.body.Write(data)
+		if rec.body.Len() > rec.maxBytes {
+			rec.overflow = true
+			rec.body.Reset()
+		}
+	}
+	return rec.ResponseWriter.Write(data)
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+)
+
+// Function to list the keys of the cache from the most recently used
+func cacheKeys(c *renderCache) []string {
+	var keys []string
+	for element := c.order.Front(); element != nil; element = element.Next() {
+		keys = append(keys, element.Value.(*cachedRender).key)
+	}
+	return keys
+}
+
+func TestRenderCacheEviction(t *testing.T) {
+	c := newRenderCache(10)
+	c.add(&cachedRender{key: "a", body: make([]byte, 4)})
+	c.add(&cachedRender{key: "b", body: make([]byte, 4)})
+	if _, ok := c.get("a"); !ok {
+		t.Fatal("a is missing")
+	}
+
+	// b is now the least recently used and makes room for c
+	c.add(&cachedRender{key: "c", body: make([]byte, 4)})
+	if got, want := cacheKeys(c), []string{"c", "a"}; !slices.Equal(got, want) {
+		t.Errorf("keys %v, want %v", got, want)
+	}
+	if _, ok := c.get("b"); ok {
+		t.Error("b was not evicted")
+	}
+
+	// A larger render evicts as many as needed
+	c.add(&cachedRender{key: "d", body: make([]byte, 8)})
+	if got, want := cacheKeys(c), []string{"d"}; !slices.Equal(got, want) {
+		t.Errorf("keys %v, want %v", got, want)
+	}
+}
+
+func TestRenderCacheBudget(t *testing.T) {
+	c := newRenderCache(10)
+	c.add(&cachedRender{key: "a", body: make([]byte, 6)})
+
+	// Replacing a render accounts for its new size only
+	c.add(&cachedRender{key: "a", body: make([]byte, 3)})
+	if c.size != 3 || len(c.entries) != 1 {
+		t.Errorf("size %d with %d entries, want 3 with 1", c.size, len(c.entries))
+	}
+
+	c.add(&cachedRender{key: "b", body: make([]byte, 7)})
+	if c.size != 10 {
+		t.Errorf("size %d, want 10", c.size)
+	}
+
+	// Renders larger than the whole cache are not kept and evict nothing
+	c.add(&cachedRender{key: "c", body: make([]byte, 11)})
+	if got, want := cacheKeys(c), []string{"b", "a"}; !slices.Equal(got, want) {
+		t.Errorf("keys %v, want %v", got, want)
+	}
+
+	for i := range 20 {
+		c.add(&cachedRender{key: string(rune('d' + i)), body: make([]byte, 1+i%4)})
+		if c.size > c.maxBytes {
+			t.Fatalf("size %d exceeds the budget of %d", c.size, c.maxBytes)
+		}
+	}
+
+	// A disabled cache never serves
+	disabled := newRenderCache(0)
+	disabled.add(&cachedRender{key: "a"})
+	if disabled.serve(httptest.NewRecorder(), "a") {
+		t.Error("disabled cache served a render")
+	}
+}
+
+func TestRenderCacheKey(t *testing.T) {
+	key := func(query string) string {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/map?"+query, nil)
+		intensities, err := parseIntensities(r.URL.Query().Get("scale"))
+		if err != nil {
+			t.Fatalf("%s: %v", query, err)
+		}
+		return renderETag(r, intensities)
+	}
+	scale := func(value string) string {
+		return "scale=" + url.QueryEscape(value)
+	}
+
+	base := key(scale(`[{"id": 13, "scale": 5}, {"id": 14, "scale": 3}]`) + "&width=640&legend=topright")
+	equal := []string{
+		// The order of the intensities and of the parameters, and the
+		// format of the payload do not matter
+		scale(`[{"id": 14, "scale": 3}, {"id": 13, "scale": 5}]`) + "&width=640&legend=topright",
+		"legend=topright&width=640&" + scale(`[{"id":13,"scale":5},{"id":14,"scale":3}]`),
+		scale("13:5,14:3") + "&width=640&legend=topright",
+		scale("14:3, 13:5") + "&legend=topright&width=640",
+	}
+	for _, query := range equal {
+		if got := key(query); got != base {
+			t.Errorf("%s: key %s, want %s", query, got, base)
+		}
+	}
+
+	different := []string{
+		scale("13:5,14:4") + "&width=640&legend=topright",
+		scale("13:5-,14:3") + "&width=640&legend=topright",
+		scale("13:5,14:3") + "&width=641&legend=topright",
+		scale("13:5,14:3,11:1") + "&width=640&legend=topright",
+	}
+	for _, query := range different {
+		if got := key(query); got == base {
+			t.Errorf("%s: shares the key of a different render", query)
+		}
+	}
+}
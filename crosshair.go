@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+)
+
+// Mean radius of the earth in kilometers
+const earthRadiusKm = 6371.0
+
+// Most distance rings drawn around the crosshair
+const maxCrosshairRings = 10
+
+// Function to get the point at the given distance (km) and bearing (degrees)
+// from a point, along the great circle
+func destinationPoint(lon, lat, distance, bearing float64) (float64, float64) {
+	const rad = math.Pi / 180
+	delta := distance / earthRadiusKm
+	phi1, lambda1, theta := lat*rad, lon*rad, bearing*rad
+
+	phi2 := math.Asin(math.Sin(phi1)*math.Cos(delta) + math.Cos(phi1)*math.Sin(delta)*math.Cos(theta))
+	lambda2 := lambda1 + math.Atan2(math.Sin(theta)*math.Sin(delta)*math.Cos(phi1),
+		math.Cos(delta)-math.Sin(phi1)*math.Sin(phi2))
+	return lambda2 / rad, phi2 / rad
+}
+
+// Function to draw a crosshair at the point with distance rings around it.
+// The rings are traced on the ground and projected, so they follow the
+// distortion of the projection.
+func drawCrosshair(canvas *svg.SVG, lon, lat float64, rings []float64, multiplier float64, funcToScreen func(float64, float64) (float64, float64)) {
+	strokeWidth := 1.5 * multiplier
+
+	for _, radius := range rings {
+		var sb strings.Builder
+		for bearing := 0.0; bearing < 360; bearing += 5 {
+			x, y := funcToScreen(destinationPoint(lon, lat, radius, bearing))
+			if sb.Len() == 0 {
+				fmt.Fprintf(&sb, "M%.1f %.1f", x, y)
+			} else {
+				fmt.Fprintf(&sb, " L%.1f %.1f", x, y)
+			}
+		}
+		sb.WriteString(" Z")
+		canvas.Path(sb.String(), fmt.Sprintf("fill:none;stroke:#fafafa;stroke-opacity:0.7;stroke-width:%.2f;stroke-dasharray:%.1f %.1f",
+			strokeWidth*0.75, 6*multiplier, 4*multiplier))
+	}
+
+	// Arms of the crosshair, leaving a gap at the center
+	x, y := funcToScreen(lon, lat)
+	gap, arm := 4*multiplier, 14*multiplier
+	path := fmt.Sprintf("M%.1f %.1f L%.1f %.1f M%.1f %.1f L%.1f %.1f M%.1f %.1f L%.1f %.1f M%.1f %.1f L%.1f %.1f",
+		x-arm, y, x-gap, y, x+gap, y, x+arm, y, x, y-arm, x, y-gap, x, y+gap, x, y+arm)
+	canvas.Path(path, fmt.Sprintf("fill:none;stroke:#18181b;stroke-width:%.2f", strokeWidth*2.5))
+	canvas.Path(path, fmt.Sprintf("fill:none;stroke:#fafafa;stroke-width:%.2f", strokeWidth))
+}
+
+// Function to draw the coordinate readout next to the crosshair and the
+// distance of each ring at its northern point
+func drawCrosshairLabels(rgba *image.RGBA, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetFontSize(12 * opts.multiplier)
+
+	lon, lat := opts.crosshair[0], opts.crosshair[1]
+	ns, ew := "N", "E"
+	if lat < 0 {
+		ns = "S"
+	}
+	if lon < 0 {
+		ew = "W"
+	}
+	readout := fmt.Sprintf("%.3f°%s %.3f°%s", math.Abs(lat), ns, math.Abs(lon), ew)
+
+	x, y := funcToScreen(lon, lat)
+	pt := freetype.Pt(int(x+18*opts.multiplier), int(y-6*opts.multiplier))
+	if _, err := c.DrawString(readout, pt); err != nil {
+		return fmt.Errorf("failed to draw crosshair readout: %w", err)
+	}
+
+	for _, radius := range opts.crosshairRings {
+		rx, ry := funcToScreen(destinationPoint(lon, lat, radius, 0))
+		pt := freetype.Pt(int(rx+4*opts.multiplier), int(ry-4*opts.multiplier))
+		if _, err := c.DrawString(fmt.Sprintf("%g km", radius), pt); err != nil {
+			return fmt.Errorf("failed to draw ring distance: %w", err)
+		}
+	}
+	return nil
+}
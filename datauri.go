@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+)
+
+// Function to check whether the image can be returned as a data URI, as
+// selected with the encoding parameter
+func isDataURIEncoding(encoding string) bool {
+	switch encoding {
+	case "png", "svg":
+		return true
+	}
+	return false
+}
+
+// Response writer collecting a rendered image so that it can be returned as
+// a data URI. Anything but a successful response, errors in particular, is
+// passed through unchanged.
+type dataURIWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (d *dataURIWriter) WriteHeader(status int) {
+	if d.status == 0 {
+		d.status = status
+	}
+	if status != http.StatusOK {
+		d.ResponseWriter.WriteHeader(status)
+	}
+}
+
+func (d *dataURIWriter) Write(data []byte) (int, error) {
+	if d.status == 0 {
+		d.status = http.StatusOK
+	}
+	if d.status != http.StatusOK {
+		return d.ResponseWriter.Write(data)
+	}
+	return d.body.Write(data)
+}
+
+// Function to write the collected image as a data URI in a text/plain
+// response, using the content type set by the render
+func (d *dataURIWriter) flush() {
+	if d.status != http.StatusOK {
+		return
+	}
+	header := d.Header()
+	uri := "data:" + header.Get("Content-Type") + ";base64," + base64.StdEncoding.EncodeToString(d.body.Bytes())
+	header.Set("Content-Type", "text/plain; charset=utf-8")
+	d.ResponseWriter.Write([]byte(uri))
+}
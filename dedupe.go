@@ -0,0 +1,43 @@
+package main
+
+import "fmt"
+
+// Handling of repeated IDs when the request does not set dedupe
+const defaultDedupeMode = "error"
+
+// Function to check whether the dedupe mode is supported
+func isDedupeMode(mode string) bool {
+	switch mode {
+	case "error", "first", "last":
+		return true
+	}
+	return false
+}
+
+// Function to resolve the IDs given more than once in the scale data. With
+// "first" or "last" that occurrence wins, with "error" an ID given with
+// different scales is rejected. Repeats with the same scale are always
+// accepted. The IDs keep the order of their first occurrence.
+func dedupeIntensities(intensities []IntensityQuery, mode string) ([]IntensityQuery, error) {
+	index := make(map[int]int, len(intensities))
+	deduped := make([]IntensityQuery, 0, len(intensities))
+	for _, intensity := range intensities {
+		i, seen := index[intensity.ID]
+		if !seen {
+			index[intensity.ID] = len(deduped)
+			deduped = append(deduped, intensity)
+			continue
+		}
+
+		previous := deduped[i]
+		conflict := previous.Scale != intensity.Scale || previous.half != intensity.half
+		switch {
+		case conflict && mode == "error":
+			return nil, fmt.Errorf("ID %d is given with different scales (%s and %s)", intensity.ID,
+				scaleText(previous.Scale, previous.half), scaleText(intensity.Scale, intensity.half))
+		case mode != "first":
+			deduped[i] = intensity
+		}
+	}
+	return deduped, nil
+}
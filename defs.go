@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// Upper bound of the client supplied SVG definitions
+const maxDefsSize = 16 << 10
+
+// Elements allowed in client supplied definitions. Anything that could run
+// script or load external content (script, foreignObject, image, use, ...)
+// is left out.
+var defsElements = map[string]bool{
+	"linearGradient": true, "radialGradient": true, "stop": true,
+	"pattern": true, "clipPath": true, "mask": true, "g": true,
+	"path": true, "rect": true, "circle": true, "ellipse": true,
+	"line": true, "polyline": true, "polygon": true,
+	"filter": true, "feGaussianBlur": true, "feOffset": true, "feBlend": true,
+	"feColorMatrix": true, "feFlood": true, "feComposite": true,
+	"feMerge": true, "feMergeNode": true, "feMorphology": true,
+}
+
+// Attributes allowed on those elements
+var defsAttributes = map[string]bool{
+	"id": true, "x": true, "y": true, "x1": true, "y1": true, "x2": true, "y2": true,
+	"cx": true, "cy": true, "r": true, "fx": true, "fy": true, "rx": true, "ry": true,
+	"width": true, "height": true, "d": true, "points": true, "viewBox": true,
+	"offset": true, "stop-color": true, "stop-opacity": true,
+	"gradientUnits": true, "gradientTransform": true, "spreadMethod": true,
+	"patternUnits": true, "patternContentUnits": true, "patternTransform": true,
+	"clipPathUnits": true, "maskUnits": true, "maskContentUnits": true,
+	"filterUnits": true, "primitiveUnits": true,
+	"fill": true, "fill-opacity": true, "fill-rule": true, "stroke": true,
+	"stroke-width": true, "stroke-opacity": true, "opacity": true,
+	"transform": true, "style": true,
+	"in": true, "in2": true, "result": true, "mode": true, "operator": true,
+	"stdDeviation": true, "dx": true, "dy": true, "type": true, "values": true,
+	"radius": true, "flood-color": true, "flood-opacity": true,
+	"k1": true, "k2": true, "k3": true, "k4": true,
+}
+
+// References to other definitions, the only form of url() that is kept
+var (
+	defsURLPattern = regexp.MustCompile(`(?i)url\(\s*([^)]*)\)`)
+	defsRefPattern = regexp.MustCompile(`^#[A-Za-z][A-Za-z0-9_-]*$`)
+)
+
+// Function to check an attribute value for anything that could escape the
+// definitions, such as external urls or script
+func isSafeDefsValue(value string) bool {
+	lower := strings.ToLower(value)
+	for _, bad := range []string{"javascript:", "expression(", "@import", "<", "\\"} {
+		if strings.Contains(lower, bad) {
+			return false
+		}
+	}
+	for _, match := range defsURLPattern.FindAllStringSubmatch(value, -1) {
+		if !defsRefPattern.MatchString(strings.Trim(strings.TrimSpace(match[1]), `"'`)) {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to sanitize client supplied SVG definitions. The input is parsed
+// and written back out with only the allowed elements and attributes, so the
+// result is well-formed and cannot close the surrounding <defs>. It returns
+// the markup along with the element name of every definition by id.
+func sanitizeDefs(input string) (string, map[string]string, error) {
+	if len(input) > maxDefsSize {
+		return "", nil, fmt.Errorf("defs are too large (%d > %d bytes)", len(input), maxDefsSize)
+	}
+
+	decoder := xml.NewDecoder(strings.NewReader("<root>" + input + "</root>"))
+	buf := new(bytes.Buffer)
+	encoder := xml.NewEncoder(buf)
+	ids := make(map[string]string)
+
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid defs markup: %w", err)
+		}
+
+		switch t := token.(type) {
+		case xml.StartElement:
+			// The wrapper and an optional <defs> around the input are dropped
+			if t.Name.Local == "root" || t.Name.Local == "defs" {
+				continue
+			}
+			if t.Name.Space != "" || !defsElements[t.Name.Local] {
+				return "", nil, fmt.Errorf("element <%s> is not allowed in defs", t.Name.Local)
+			}
+
+			element := xml.StartElement{Name: xml.Name{Local: t.Name.Local}}
+			for _, attr := range t.Attr {
+				if attr.Name.Space == "xmlns" || (attr.Name.Space == "" && attr.Name.Local == "xmlns") {
+					continue
+				}
+				if attr.Name.Space != "" || !defsAttributes[attr.Name.Local] {
+					return "", nil, fmt.Errorf("attribute %s is not allowed in defs", attr.Name.Local)
+				}
+				if !isSafeDefsValue(attr.Value) {
+					return "", nil, fmt.Errorf("invalid value of attribute %s in defs", attr.Name.Local)
+				}
+				if attr.Name.Local == "id" {
+					if !defsRefPattern.MatchString("#" + attr.Value) {
+						return "", nil, fmt.Errorf("invalid id in defs: %q", attr.Value)
+					}
+					ids[attr.Value] = t.Name.Local
+				}
+				element.Attr = append(element.Attr, xml.Attr{Name: xml.Name{Local: attr.Name.Local}, Value: attr.Value})
+			}
+			if err := encoder.EncodeToken(element); err != nil {
+				return "", nil, err
+			}
+
+		case xml.EndElement:
+			if t.Name.Local == "root" || t.Name.Local == "defs" {
+				continue
+			}
+			if err := encoder.EncodeToken(xml.EndElement{Name: xml.Name{Local: t.Name.Local}}); err != nil {
+				return "", nil, err
+			}
+
+		case xml.CharData:
+			// Definitions carry no text, only whitespace is kept
+			if len(bytes.TrimSpace(t)) > 0 {
+				return "", nil, errors.New("text content is not allowed in defs")
+			}
+		}
+		// Comments, processing instructions and directives are dropped
+	}
+
+	if err := encoder.Flush(); err != nil {
+		return "", nil, err
+	}
+	return buf.String(), ids, nil
+}
+
+// Function to validate a fill override, which is either a hex color or a
+// reference to one of the definitions. Raster output only supports gradients
+// since oksvg ignores patterns and filters.
+func validateFill(fill string, ids map[string]string, raster bool) error {
+	if _, err := hexToRGBA(fill); err == nil {
+		return nil
+	}
+
+	match := defsURLPattern.FindStringSubmatch(fill)
+	if match == nil || match[0] != fill {
+		return fmt.Errorf("fill must be a #rrggbb color or url(#id): %q", fill)
+	}
+	id := strings.TrimPrefix(strings.TrimSpace(match[1]), "#")
+	element, ok := ids[id]
+	if !ok {
+		return fmt.Errorf("fill references an unknown definition: %q", id)
+	}
+	if raster && element != "linearGradient" && element != "radialGradient" {
+		return fmt.Errorf("fill references a <%s>, only gradients can be rendered", element)
+	}
+	return nil
+}
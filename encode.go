@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"time"
+)
+
+// Maximum duration of the PNG encode step, configured with ENCODE_TIMEOUT.
+// Zero means the encode is only bound by the request context.
+var encodeTimeout time.Duration
+
+// Writer that fails as soon as the context is done, which makes the encoder
+// stop at its next write instead of finishing the whole image
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+	if err := cw.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return cw.w.Write(p)
+}
+
+// Function to encode the image as PNG, aborting when the client disconnects
+// or the encode timeout is exceeded
+func encodePNG(ctx context.Context, img image.Image) ([]byte, error) {
+	return encodePNGLevel(ctx, img, png.DefaultCompression)
+}
+
+// Compression levels of the PNG output, selected with the compression
+// parameter
+var pngCompressionLevels = map[string]png.CompressionLevel{
+	"default": png.DefaultCompression,
+	"best":    png.BestCompression,
+	"fast":    png.BestSpeed,
+	"none":    png.NoCompression,
+}
+
+// Function to encode the image as PNG with the given compression level
+func encodePNGLevel(ctx context.Context, img image.Image, level png.CompressionLevel) ([]byte, error) {
+	encoder := &png.Encoder{CompressionLevel: level}
+	return encodeWithContext(ctx, func(w io.Writer) error {
+		return encoder.Encode(w, img)
+	})
+}
+
+// Function to encode the image as JPEG with the given quality (1-100)
+func encodeJPEG(ctx context.Context, img image.Image, quality int) ([]byte, error) {
+	return encodeWithContext(ctx, func(w io.Writer) error {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	})
+}
+
+// Function to composite the image over an opaque background, for formats
+// without an alpha channel
+func flattenImage(img *image.RGBA, background color.Color) *image.RGBA {
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(background), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+	return flat
+}
+
+// Function to run an encoder in the background, returning early with the
+// context error when the context is done before the encoder finishes
+func encodeWithContext(ctx context.Context, encode func(w io.Writer) error) ([]byte, error) {
+	if encodeTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, encodeTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		data []byte
+		err  error
+	}
+	done := make(chan result, 1)
+
+	go func() {
+		var buf bytes.Buffer
+		err := encode(&contextWriter{ctx: ctx, w: &buf})
+		done <- result{buf.Bytes(), err}
+	}()
+
+	select {
+	case res := <-done:
+		return res.data, res.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
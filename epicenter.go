@@ -0,0 +1,69 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+)
+
+// Deepest hypocenter accepted, in kilometers
+const maxEpicenterDepth = 700
+
+// Function to get the outline of the five-pointed star marking the epicenter
+func epicenterPath(x, y, radius float64) string {
+	var sb strings.Builder
+	for i := 0; i < 10; i++ {
+		r := radius
+		if i%2 == 1 {
+			r = radius * 0.45
+		}
+		angle := -math.Pi/2 + float64(i)*math.Pi/5
+		command := "L"
+		if i == 0 {
+			command = "M"
+		}
+		fmt.Fprintf(&sb, "%s%.1f %.1f ", command, x+r*math.Cos(angle), y+r*math.Sin(angle))
+	}
+	sb.WriteString("Z")
+	return sb.String()
+}
+
+// Function to draw the epicenter marker, along with its depth as SVG text
+// for the vector output
+func drawEpicenter(canvas *svg.SVG, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) {
+	x, y := funcToScreen(opts.epicenter[0], opts.epicenter[1])
+	m := opts.multiplier
+	canvas.Path(epicenterPath(x, y, 10*m),
+		fmt.Sprintf("fill:#dc2626;stroke:#fafafa;stroke-width:%.2f;stroke-linejoin:round", 1.5*m))
+
+	if opts.vector && opts.epicenterDepth >= 0 {
+		canvas.Text(int(x+14*m), int(y+4*m), epicenterDepthText(opts.epicenterDepth),
+			fmt.Sprintf("fill:#fafafa;font-family:%s;font-size:%.1fpx", opts.fontFile().family, 12*m))
+	}
+}
+
+// Function to format the depth of the hypocenter
+func epicenterDepthText(depth float64) string {
+	return fmt.Sprintf("Depth %g km", depth)
+}
+
+// Function to draw the depth of the hypocenter next to the epicenter marker
+func drawEpicenterDepth(rgba *image.RGBA, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetFontSize(12 * opts.multiplier)
+
+	x, y := funcToScreen(opts.epicenter[0], opts.epicenter[1])
+	pt := freetype.Pt(int(x+14*opts.multiplier), int(y+4*opts.multiplier))
+	if _, err := c.DrawString(epicenterDepthText(opts.epicenterDepth), pt); err != nil {
+		return fmt.Errorf("failed to draw epicenter depth: %w", err)
+	}
+	return nil
+}
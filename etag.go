@@ -0,0 +1,86 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// How long clients and proxies may reuse a rendered map
+const renderCacheControl = "public, max-age=86400"
+
+// Revision of the running build, part of every ETag so that tags issued by
+// an older renderer are not matched once the output changes
+var renderRevision = buildRevision()
+
+// Function to get the VCS revision the binary was built from
+func buildRevision() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+	revision := info.Main.Version
+	for _, setting := range info.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			revision = setting.Value
+		case "vcs.modified":
+			if setting.Value == "true" {
+				revision += "+dirty"
+			}
+		}
+	}
+	return revision
+}
+
+// Function to compute the ETag of a render from everything that affects the
+// output: the endpoint, the query and the intensities. The intensities are
+// sorted by ID so that equivalent payloads share a tag. Maps loaded from a
+// remote URL can change at any time and renders stamped with the current
+// time differ every time, neither gets a tag.
+func renderETag(r *http.Request, intensities []IntensityQuery) string {
+	query := r.URL.Query()
+	if query.Has("geojsonUrl") || query.Get("timestamp") == "true" {
+		return ""
+	}
+	query.Del("scale")
+
+	sorted := append([]IntensityQuery{}, intensities...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].ID < sorted[j].ID })
+	scale, err := json.Marshal(sorted)
+	if err != nil {
+		return ""
+	}
+
+	h := sha256.New()
+	for _, part := range []string{renderRevision, r.URL.Path, query.Encode(), string(scale)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return `"` + hex.EncodeToString(h.Sum(nil)[:16]) + `"`
+}
+
+// Function to check whether an If-None-Match header matches the ETag, using
+// the weak comparison required for conditional GETs
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to set the caching headers of a successful render
+func setCacheHeaders(w http.ResponseWriter, etag string) {
+	if etag == "" {
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", renderCacheControl)
+}
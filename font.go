@@ -0,0 +1,90 @@
+package main
+
+import (
+	"embed"
+	"os"
+	"path"
+	"path/filepath"
+	"sync"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+)
+
+// Directory holding the font files
+const fontsDir = "fonts"
+
+// Roboto is built into the binary so that text can always be drawn, even
+// when deployed without fontsDir
+//
+//go:embed fonts/roboto-regular.ttf fonts/roboto-medium.ttf
+var embeddedFonts embed.FS
+
+// Font used when the request does not select one
+const defaultFont = "roboto"
+
+// Font that can be selected with the font parameter
+type fontFile struct {
+	file   string // File name in fontsDir
+	family string // CSS font family used in the SVG output
+}
+
+// Fonts by name. Only the Roboto files are embedded, the CJK fonts are large
+// and become available once their file is added to fontsDir.
+var fontFiles = map[string]fontFile{
+	"roboto":        {"roboto-regular.ttf", "Roboto,sans-serif"},
+	"roboto-medium": {"roboto-medium.ttf", "Roboto,sans-serif"},
+	"notosans-jp":   {"noto-sans-jp-regular.ttf", "'Noto Sans JP',sans-serif"},
+}
+
+// Parsed fonts by file name, so each file is only read and parsed once. The
+// lock is held while parsing, which keeps concurrent requests on a cold cache
+// from parsing the same file twice.
+var (
+	fontCacheMu sync.Mutex
+	fontCache   = map[string]*truetype.Font{}
+)
+
+// Function to load and parse a font file from fontsDir, reusing the parsed
+// font of earlier calls. The name must come from fontFiles, never from the
+// request, which keeps the path inside fontsDir.
+func loadFontFile(name string) (*truetype.Font, error) {
+	fontCacheMu.Lock()
+	defer fontCacheMu.Unlock()
+	if f, ok := fontCache[name]; ok {
+		return f, nil
+	}
+
+	fontBytes, err := readFontFile(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := freetype.ParseFont(fontBytes)
+	if err != nil {
+		return nil, err
+	}
+	fontCache[name] = f
+	return f, nil
+}
+
+// Function to read a font file, from the embedded fonts when it is one of
+// them and from fontsDir otherwise
+func readFontFile(name string) ([]byte, error) {
+	if data, err := embeddedFonts.ReadFile(path.Join(fontsDir, name)); err == nil {
+		return data, nil
+	}
+	return os.ReadFile(filepath.Join(fontsDir, name))
+}
+
+// Function to get the font selected for the text of the request
+func (opts renderOptions) fontFile() fontFile {
+	if font, ok := fontFiles[opts.font]; ok {
+		return font
+	}
+	return fontFiles[defaultFont]
+}
+
+// Function to load the font selected for the text of the request
+func (opts renderOptions) loadFont() (*truetype.Font, error) {
+	return loadFontFile(opts.fontFile().file)
+}
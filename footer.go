@@ -0,0 +1,63 @@
+package main
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+)
+
+// Smallest size the footer is shrunk to in order to fit, in points before
+// following the output scale
+const minFooterSize = 8.0
+
+// Function to get the width available to a footer line in pixels, leaving
+// the same margin on both sides
+func (opts renderOptions) footerMaxWidth() float64 {
+	return float64(opts.width) - 2*10*opts.multiplier
+}
+
+// Function to measure the width of the text in pixels with the size and
+// resolution of the footer
+func footerMeasure(f *truetype.Font, opts renderOptions) func(string) float64 {
+	face := truetype.NewFace(f, &truetype.Options{Size: opts.footerSize, DPI: opts.dpi})
+	return func(text string) float64 {
+		return float64(font.MeasureString(face, text)) / 64
+	}
+}
+
+// Function to get the footer size at which its longest line fits within the
+// canvas. The size is not reduced below minFooterSize, lines still too long
+// are cut short by truncateText when drawn.
+func fitFooterSize(f *truetype.Font, opts renderOptions) float64 {
+	measure := footerMeasure(f, opts)
+	widest := 0.0
+	for _, line := range opts.footerLines() {
+		widest = max(widest, measure(line))
+	}
+	if widest <= opts.footerMaxWidth() {
+		return opts.footerSize
+	}
+	return max(minFooterSize*opts.multiplier, opts.footerSize*opts.footerMaxWidth()/widest)
+}
+
+// Function to cut the text short with an ellipsis so that it fits within the
+// width, as measured by measure. The longest fitting prefix is searched
+// since the width grows with the length.
+func truncateText(text string, maxWidth float64, measure func(string) float64) string {
+	if measure(text) <= maxWidth {
+		return text
+	}
+	runes := []rune(text)
+	truncate := func(n int) string {
+		return strings.TrimRight(string(runes[:n]), " ") + "…"
+	}
+	n := sort.Search(len(runes), func(n int) bool {
+		return measure(truncate(n+1)) > maxWidth
+	})
+	if n == 0 {
+		return "…"
+	}
+	return truncate(n)
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/golang/freetype"
+)
+
+// Function to pick the color of the freshness indicator, green while the data
+// is fresh, yellow after warn and red once it is stale
+func freshnessColor(age, warn, stale time.Duration) color.RGBA {
+	switch {
+	case age >= stale:
+		return color.RGBA{0xdc, 0x26, 0x26, 0xff}
+	case age >= warn:
+		return color.RGBA{0xfa, 0xcc, 0x15, 0xff}
+	default:
+		return color.RGBA{0x4a, 0xde, 0x80, 0xff}
+	}
+}
+
+// Function to format the age of the data in its largest whole unit
+func formatAge(age time.Duration) string {
+	switch {
+	case age < time.Minute:
+		return fmt.Sprintf("%ds ago", int(age.Seconds()))
+	case age < time.Hour:
+		return fmt.Sprintf("%dm ago", int(age.Minutes()))
+	case age < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(age.Hours()))
+	default:
+		return fmt.Sprintf("%dd ago", int(age.Hours()/24))
+	}
+}
+
+// Function to draw a colored dot with the age of the data in the top left
+// corner of the image
+func drawFreshness(img *image.RGBA, c *textRenderer, age, warn, stale time.Duration, multiplier float64) error {
+	margin := 16 * multiplier
+	radius := 5 * multiplier
+	cx, cy := margin+radius, margin+radius
+	dot := freshnessColor(age, warn, stale)
+
+	// Anti-aliased disc
+	rect := image.Rect(int(cx-radius)-1, int(cy-radius)-1, int(cx+radius)+2, int(cy+radius)+2).Intersect(img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			alpha := math.Max(0, math.Min(1, radius-d+0.5))
+			if alpha > 0 {
+				blendPixel(img, x, y, color.NRGBA{dot.R, dot.G, dot.B, uint8(alpha*255 + 0.5)})
+			}
+		}
+	}
+
+	c.SetFontSize(12 * multiplier)
+	pt := freetype.Pt(int(cx+radius+6*multiplier), int(cy+4*multiplier))
+	if _, err := c.DrawString(formatAge(age), pt); err != nil {
+		return fmt.Errorf("failed to draw data age: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"io"
+	"sort"
+	"time"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Most frames an animation may have, and the pixels of all its frames
+// together which stay within the largest single image (8192x8192)
+const (
+	maxAnimationFrames = 60
+	maxAnimationPixels = 8192 * 8192
+)
+
+// Returned when an animation has more frames than the limits allow
+var errTooManyFrames = errors.New("too many frames for an animation")
+
+// Function to render an animation that reveals the prefectures one intensity
+// tier at a time ("desc" starts with the strongest shaking). The bounds stay
+// fixed across frames so the map does not jump.
+func renderRevealGIF(ctx context.Context, fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64), order string, delay int) ([]byte, error) {
+	// Collect the distinct nonzero intensities
+	seen := make(map[int]bool)
+	var tiers []int
+	for _, scale := range scaleMap {
+		if scale != 0 && !seen[scale] {
+			seen[scale] = true
+			tiers = append(tiers, scale)
+		}
+	}
+	sort.Ints(tiers)
+	if order == "desc" {
+		sort.Sort(sort.Reverse(sort.IntSlice(tiers)))
+	}
+
+	// The first frame shows the map without any intensity, each following
+	// frame adds the next tier
+	frames := []map[int]int{{}}
+	for _, tier := range tiers {
+		subset := make(map[int]int)
+		for id, scale := range frames[len(frames)-1] {
+			subset[id] = scale
+		}
+		for id, scale := range scaleMap {
+			if scale == tier {
+				subset[id] = scale
+			}
+		}
+		frames = append(frames, subset)
+	}
+	delays := make([]int, len(frames))
+	for i := range delays {
+		delays[i] = delay
+	}
+	// Hold the last frame a little longer before looping
+	delays[len(delays)-1] *= 3
+	return renderAnimation(ctx, fc, frames, opts, funcToScreen, delays)
+}
+
+// Function to render an animated GIF with one frame per scale map, shown for
+// the delay of the frame in milliseconds. The frames share one palette so
+// that the colors do not flicker between them.
+func renderAnimation(ctx context.Context, fc *geojson.FeatureCollection, frames []map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64), delays []int) ([]byte, error) {
+	if len(frames) > maxAnimationFrames || len(frames)*opts.width*opts.height > maxAnimationPixels {
+		return nil, errTooManyFrames
+	}
+
+	images := make([]*image.RGBA, 0, len(frames))
+	for i, frame := range frames {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		// A color given for a prefecture only shows once the frame
+		// affects it, so the reveal does not give it away early
+		frameOpts := opts
+		frameOpts.colors = make(map[int]string, len(opts.colors))
+		for id, fill := range opts.colors {
+			if frame[id] != 0 {
+				frameOpts.colors[id] = fill
+			}
+		}
+
+		rgba, err := renderImage(fc, frame, frameOpts, funcToScreen)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render frame %d: %w", i, err)
+		}
+		images = append(images, rgba)
+	}
+
+	// The palette is written once as the global color table
+	palette := sharedPalette(images, basePalette(opts))
+	anim := &gif.GIF{Config: image.Config{ColorModel: palette, Width: opts.width, Height: opts.height}}
+	for i, rgba := range images {
+		anim.Image = append(anim.Image, quantize(rgba, palette))
+		anim.Delay = append(anim.Delay, delays[i]/10) // GIF delays are in 1/100s
+	}
+
+	data, err := encodeWithContext(ctx, func(w io.Writer) error {
+		return gif.EncodeAll(w, anim)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode gif: %w", err)
+	}
+	return data, nil
+}
+
+// A snapshot of the intensities at a point in time
+type AnimationFrame struct {
+	Time  time.Time        `json:"time"`
+	Scale []IntensityQuery `json:"scale"`
+	Delay int              `json:"delay,omitempty"` // Milliseconds the frame is shown, 0 for the delay parameter
+}
+
+// Function to sort the frames by time and keep those within the window
+// before the newest frame
+func framesInWindow(frames []AnimationFrame, window time.Duration) []AnimationFrame {
+	sort.SliceStable(frames, func(i, j int) bool {
+		return frames[i].Time.Before(frames[j].Time)
+	})
+	if len(frames) == 0 {
+		return frames
+	}
+
+	start := frames[len(frames)-1].Time.Add(-window)
+	var result []AnimationFrame
+	for _, frame := range frames {
+		if !frame.Time.Before(start) {
+			result = append(result, frame)
+		}
+	}
+	return result
+}
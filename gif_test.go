@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestAnimationFrameLimit(t *testing.T) {
+	defer func(c *renderCache) { renders = c }(renders)
+	renders = newRenderCache(0)
+
+	frames := make([]AnimationFrame, maxAnimationFrames+1)
+	for i := range frames {
+		frames[i] = AnimationFrame{
+			Time:  time.Date(2024, 1, 1, 0, 0, i, 0, time.UTC),
+			Scale: []IntensityQuery{{ID: 13, Scale: 3}},
+		}
+	}
+	data, err := json.Marshal(frames)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+	// A couple of frames already exceed the pixel budget at the largest size
+	few, err := json.Marshal(frames[:2])
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	tests := []struct {
+		name  string
+		query url.Values
+	}{
+		{"too many frames", url.Values{"frames": {string(data)}}},
+		{"too many frames in the window", url.Values{"frames": {string(data)}, "window": {"24h"}}},
+		{"too many pixels", url.Values{"frames": {string(few)}, "width": {"8192"}, "height": {"8192"}}},
+	}
+
+	for _, tt := range tests {
+		tt.query.Set("format", "gif")
+		tt.query.Set("map", "kanto")
+		rec := httptest.NewRecorder()
+		mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+tt.query.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, want %d: %s", tt.name, rec.Code, http.StatusBadRequest, rec.Body.String())
+		}
+	}
+
+	// The renderer itself refuses as well
+	maps := make([]map[int]int, maxAnimationFrames+1)
+	opts := renderOptions{width: 16, height: 16}
+	if _, err := renderAnimation(context.Background(), nil, maps, opts, nil, nil); !errors.Is(err, errTooManyFrames) {
+		t.Errorf("renderAnimation: error %v, want %v", err, errTooManyFrames)
+	}
+}
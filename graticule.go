@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+)
+
+// Segments used to trace each graticule line, enough for the conic
+// projections to curve smoothly
+const graticuleSegments = 32
+
+// Function to draw the lines of latitude and longitude at multiples of step
+// (in degrees) over the visible area of the map. The visible range is
+// estimated from the scale at the center of the map and padded on every
+// side, the canvas clips whatever falls outside.
+func drawGraticule(canvas *svg.SVG, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) {
+	lon, lat := opts.center[0], opts.center[1]
+	x0, y0 := funcToScreen(lon, lat)
+	x1, _ := funcToScreen(lon+1, lat)
+	_, y1 := funcToScreen(lon, lat+1)
+	pixelsPerLon, pixelsPerLat := math.Abs(x1-x0), math.Abs(y1-y0)
+	if pixelsPerLon == 0 || pixelsPerLat == 0 {
+		return
+	}
+
+	// Each half of the range covers the full canvas, which leaves room
+	// for the center being off the middle of the canvas
+	lonSpan := math.Min(180, float64(opts.width)/pixelsPerLon)
+	latSpan := math.Min(85, float64(opts.height)/pixelsPerLat)
+	minLon := math.Floor((lon-lonSpan)/opts.graticuleStep) * opts.graticuleStep
+	maxLon := math.Ceil((lon+lonSpan)/opts.graticuleStep) * opts.graticuleStep
+	minLat := math.Floor((math.Max(-85, lat-latSpan))/opts.graticuleStep) * opts.graticuleStep
+	maxLat := math.Ceil((math.Min(85, lat+latSpan))/opts.graticuleStep) * opts.graticuleStep
+
+	var sb strings.Builder
+	trace := func(point func(t float64) (float64, float64)) {
+		for i := 0; i <= graticuleSegments; i++ {
+			x, y := funcToScreen(point(float64(i) / graticuleSegments))
+			command := " L"
+			if i == 0 {
+				command = " M"
+			}
+			fmt.Fprintf(&sb, "%s%.1f %.1f", command, x, y)
+		}
+	}
+	// Stepping by index keeps the lines on exact multiples of the step
+	for i := 0; minLon+float64(i)*opts.graticuleStep <= maxLon; i++ {
+		meridian := minLon + float64(i)*opts.graticuleStep
+		trace(func(t float64) (float64, float64) { return meridian, minLat + t*(maxLat-minLat) })
+	}
+	for i := 0; minLat+float64(i)*opts.graticuleStep <= maxLat; i++ {
+		parallel := minLat + float64(i)*opts.graticuleStep
+		trace(func(t float64) (float64, float64) { return minLon + t*(maxLon-minLon), parallel })
+	}
+
+	canvas.Path(strings.TrimSpace(sb.String()),
+		fmt.Sprintf("fill:none;stroke:#fafafa;stroke-opacity:0.15;stroke-width:%.2f", math.Max(0.5, 0.5*opts.multiplier)))
+}
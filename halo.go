@@ -0,0 +1,57 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"sort"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Function to draw a soft halo of the fill color around the prefectures
+// whose intensity is uncertain, reading as an approximate extent
+func drawUncertaintyHalos(img *image.RGBA, features []*geojson.Feature, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	// Group the uncertain features by fill so each color is blurred once,
+	// a color override tints the halo like the prefecture it surrounds
+	groups := make(map[string][]*geojson.Feature)
+	for _, feature := range features {
+		id, ok := feature.Properties["id"].(float64)
+		if !ok || !opts.uncertain[int(id)] {
+			continue
+		}
+		fill := opts.fillColor(int(id), scaleMap[int(id)])
+		groups[fill] = append(groups[fill], feature)
+	}
+
+	fills := make([]string, 0, len(groups))
+	for fill := range groups {
+		fills = append(fills, fill)
+	}
+	sort.Strings(fills)
+
+	radius := int(6 * opts.multiplier)
+	if radius < 1 {
+		radius = 1
+	}
+
+	for _, hex := range fills {
+		mask, err := renderFeatureMask(groups[hex], opts, funcToScreen)
+		if err != nil {
+			return err
+		}
+		blurred := blurAlpha(mask, radius)
+
+		fill, _ := hexToRGBA(hex)
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				a := blurred.AlphaAt(x, y).A
+				if a == 0 {
+					continue
+				}
+				blendPixel(img, x, y, color.NRGBA{fill.R, fill.G, fill.B, uint8(float64(a) * 0.7)})
+			}
+		}
+	}
+	return nil
+}
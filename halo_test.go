@@ -0,0 +1,33 @@
+package main
+
+import (
+	"image"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+func TestUncertaintyHaloColorOverride(t *testing.T) {
+	feature := geojson.NewPolygonFeature([][][]float64{{{20, 20}, {44, 20}, {44, 44}, {20, 44}, {20, 20}}})
+	feature.Properties["id"] = float64(13)
+
+	opts := renderOptions{
+		width:      64,
+		height:     64,
+		multiplier: 1,
+		uncertain:  map[int]bool{13: true},
+		colors:     map[int]string{13: "#00ff00"},
+	}
+	identity := func(x, y float64) (float64, float64) { return x, y }
+
+	img := image.NewRGBA(image.Rect(0, 0, opts.width, opts.height))
+	if err := drawUncertaintyHalos(img, []*geojson.Feature{feature}, map[int]int{13: 7}, opts, identity); err != nil {
+		t.Fatalf("drawUncertaintyHalos: %v", err)
+	}
+
+	// Just outside the prefecture the halo is in the override color
+	c := img.RGBAAt(17, 32)
+	if c.A == 0 || c.G <= c.R || c.G <= c.B {
+		t.Errorf("halo pixel = %v, want the override green", c)
+	}
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Status reported by the health check
+type HealthStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// Reports whether the map and the default font are loaded, so that a load
+// balancer only routes requests to instances able to render. Nothing is
+// rendered, the font is read from the cache after the first check.
+func healthHandler(w http.ResponseWriter, r *http.Request) {
+	status := HealthStatus{Status: "ok"}
+	code := http.StatusOK
+	if _, ok := baseMaps[defaultBaseMap]; !ok {
+		status = HealthStatus{Status: "unavailable", Error: "map is not loaded"}
+		code = http.StatusServiceUnavailable
+	} else if _, err := loadFontFile(fontFiles[defaultFont].file); err != nil {
+		status = HealthStatus{Status: "unavailable", Error: "font is not loaded"}
+		code = http.StatusServiceUnavailable
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
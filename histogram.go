@@ -0,0 +1,166 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+)
+
+// Function to count the prefectures of each intensity, indexed by the
+// intensity (1-7)
+func histogramCounts(scaleMap map[int]int) []int {
+	counts := make([]int, 8)
+	for _, scale := range scaleMap {
+		if scale >= 1 && scale <= 7 {
+			counts[scale]++
+		}
+	}
+	return counts
+}
+
+// Function to get the width of the histogram sidebar in pixels, zero when
+// it is not drawn
+func (opts renderOptions) histogramWidth() float64 {
+	if opts.histogram == nil {
+		return 0
+	}
+	return min(240*opts.multiplier, float64(opts.width)/3)
+}
+
+// Function to get the width of the canvas left to the map, which the overlays
+// along the right edge keep to
+func (opts renderOptions) mapWidth() float64 {
+	return float64(opts.width) - opts.histogramWidth()
+}
+
+// Function to get the colors of the sidebar and its text. The sidebar takes
+// the background of the map, a transparent one is replaced with the dark
+// panel of the legend so that the map cannot show through.
+func (opts renderOptions) histogramColors() (panel, text color.RGBA) {
+	panel = opts.background
+	if panel.A != 0xff {
+		panel = color.RGBA{0x18, 0x18, 0x1b, 0xff}
+	}
+	if 0.299*float64(panel.R)+0.587*float64(panel.G)+0.114*float64(panel.B) < 128 {
+		return panel, color.RGBA{0xfa, 0xfa, 0xfa, 0xff}
+	}
+	return panel, color.RGBA{0x18, 0x18, 0x1b, 0xff}
+}
+
+// Bar of the histogram, with the intensity to its left and the count to its
+// right
+type histogramBar struct {
+	scale         int
+	count         int
+	x, y          float64 // Top left of the bar
+	length        float64
+	labelX        float64
+	countX, textY float64 // Position of the count and baseline of the texts
+}
+
+// Placement of the histogram in the sidebar
+type histogramLayout struct {
+	x, width  float64
+	titleY    float64 // Baseline of the title
+	barHeight float64
+	bars      []histogramBar
+}
+
+// Function to lay out the bars of the sidebar, the highest intensity on top.
+// The bars are scaled to the most common intensity and stay clear of the
+// footer.
+func newHistogramLayout(opts renderOptions) histogramLayout {
+	m := opts.multiplier
+	pad := 16 * m
+	l := histogramLayout{x: opts.mapWidth(), width: opts.histogramWidth()}
+	l.titleY = pad + 12*m
+
+	top := l.titleY + 12*m
+	bottom := float64(opts.height) - pad - opts.footerClearance()
+	rowHeight := max(0, min(32*m, (bottom-top)/7))
+	l.barHeight = 0.6 * rowHeight
+
+	most := 1
+	for _, count := range opts.histogram {
+		if count > most {
+			most = count
+		}
+	}
+	labelWidth, countWidth := 16*m, 32*m
+	maxLength := max(0, l.width-2*pad-labelWidth-countWidth)
+	for i := 0; i < 7; i++ {
+		scale := 7 - i
+		count := opts.histogram[scale]
+		y := top + float64(i)*rowHeight
+		bar := histogramBar{
+			scale:  scale,
+			count:  count,
+			x:      l.x + pad + labelWidth,
+			y:      y + (rowHeight-l.barHeight)/2,
+			length: maxLength * float64(count) / float64(most),
+			labelX: l.x + pad,
+			textY:  y + rowHeight/2 + 4*m,
+		}
+		bar.countX = bar.x + bar.length + 4*m
+		l.bars = append(l.bars, bar)
+	}
+	return l
+}
+
+// Function to draw the histogram sidebar as SVG elements, used for the
+// vector output
+func drawHistogramSVG(canvas *svg.SVG, opts renderOptions) {
+	l := newHistogramLayout(opts)
+	panel, text := opts.histogramColors()
+	canvas.Rect(int(l.x), 0, int(l.width)+1, opts.height,
+		fmt.Sprintf("fill:#%02x%02x%02x", panel.R, panel.G, panel.B))
+
+	textStyle := fmt.Sprintf("fill:#%02x%02x%02x;font-family:%s;font-size:%.1fpx",
+		text.R, text.G, text.B, opts.fontFile().family, 12*opts.multiplier)
+	canvas.Text(int(l.x+16*opts.multiplier), int(l.titleY), "Prefectures by intensity", textStyle)
+	for _, bar := range l.bars {
+		canvas.Text(int(bar.labelX), int(bar.textY), strconv.Itoa(bar.scale), textStyle)
+		if bar.count > 0 {
+			canvas.Rect(int(bar.x), int(bar.y), int(max(1, bar.length)), int(l.barHeight),
+				"fill:"+opts.legendFill(bar.scale))
+		}
+		canvas.Text(int(bar.countX), int(bar.textY), strconv.Itoa(bar.count), textStyle)
+	}
+}
+
+// Function to draw the histogram sidebar onto the rendered image, covering
+// the parts of the map reaching into it
+func drawHistogram(img *image.RGBA, c *textRenderer, opts renderOptions) error {
+	l := newHistogramLayout(opts)
+	panel, text := opts.histogramColors()
+	draw.Draw(img, image.Rect(int(l.x), 0, opts.width, opts.height), image.NewUniform(panel), image.Point{}, draw.Src)
+
+	c.SetColor(text)
+	defer c.SetColor(color.RGBA{0xfa, 0xfa, 0xfa, 0xff})
+	c.SetFontSize(12 * opts.multiplier)
+	if _, err := c.DrawString("Prefectures by intensity", freetype.Pt(int(l.x+16*opts.multiplier), int(l.titleY))); err != nil {
+		return fmt.Errorf("failed to draw histogram title: %w", err)
+	}
+	for _, bar := range l.bars {
+		if _, err := c.DrawString(strconv.Itoa(bar.scale), freetype.Pt(int(bar.labelX), int(bar.textY))); err != nil {
+			return fmt.Errorf("failed to draw histogram label: %w", err)
+		}
+		if bar.count > 0 {
+			fill, err := hexToRGBA(opts.legendFill(bar.scale))
+			if err != nil {
+				fill, _ = hexToRGBA(opts.intensityColor(bar.scale))
+			}
+			rect := image.Rect(int(bar.x), int(bar.y), int(bar.x+max(1, bar.length)), int(bar.y+l.barHeight))
+			draw.Draw(img, rect, image.NewUniform(fill), image.Point{}, draw.Src)
+		}
+		if _, err := c.DrawString(strconv.Itoa(bar.count), freetype.Pt(int(bar.countX), int(bar.textY))); err != nil {
+			return fmt.Errorf("failed to draw histogram count: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+)
+
+// Function to convert a hex color string (#rrggbb) to RGBA
+func hexToRGBA(hex string) (color.RGBA, error) {
+	var c color.RGBA
+	if len(hex) != 7 || hex[0] != '#' {
+		return c, fmt.Errorf("invalid hex color: %q", hex)
+	}
+	if _, err := fmt.Sscanf(hex, "#%02x%02x%02x", &c.R, &c.G, &c.B); err != nil {
+		return c, fmt.Errorf("invalid hex color: %q", hex)
+	}
+	c.A = 0xff
+	return c, nil
+}
+
+// Function to blend a color over a background with the given opacity. Both
+// colors are premultiplied, so a transparent background is blended as well.
+func blend(fg, bg color.RGBA, opacity float64) color.RGBA {
+	mix := func(a, b uint8) uint8 {
+		return uint8(float64(a)*opacity + float64(b)*(1-opacity) + 0.5)
+	}
+	return color.RGBA{mix(fg.R, bg.R), mix(fg.G, bg.G), mix(fg.B, bg.B), mix(fg.A, bg.A)}
+}
+
+// Function to derive the fixed colors of the map (background, stroke, text
+// and the intensity fills as they appear over the background, along with
+// the plain intensity colors of the outlines)
+func basePalette(opts renderOptions) color.Palette {
+	bg := opts.background
+	stroke, _ := hexToRGBA(opts.strokeColor)
+
+	palette := color.Palette{bg, stroke, opts.footerColor}
+	if opts.contextLayer != nil {
+		context, _ := hexToRGBA(contextOutlineColor)
+		palette = append(palette, context)
+	}
+	for scale := 0; scale <= 7; scale++ {
+		fill, _ := hexToRGBA(opts.intensityColor(scale))
+		palette = append(palette, blend(fill, bg, opts.fillOpacity))
+		if opts.outline {
+			palette = append(palette, fill)
+		}
+	}
+	return palette
+}
+
+// Function to quantize an RGBA image into a paletted image. The base colors
+// are always kept and the remaining slots are filled with the most frequent
+// colors of the image (anti-aliased edges, text, etc.)
+func toPaletted(img *image.RGBA, base color.Palette) *image.Paletted {
+	return quantize(img, sharedPalette([]*image.RGBA{img}, base))
+}
+
+// Function to build a palette of up to 256 colors for the images, starting
+// with the base colors and filling the remaining slots with the most
+// frequent colors across all images
+func sharedPalette(images []*image.RGBA, base color.Palette) color.Palette {
+	counts := make(map[color.RGBA]int)
+	for _, img := range images {
+		bounds := img.Bounds()
+		for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+			for x := bounds.Min.X; x < bounds.Max.X; x++ {
+				counts[img.RGBAAt(x, y)]++
+			}
+		}
+	}
+
+	palette := make(color.Palette, 0, 256)
+	seen := make(map[color.RGBA]bool)
+	for _, c := range base {
+		rgba := color.RGBAModel.Convert(c).(color.RGBA)
+		if !seen[rgba] && len(palette) < 256 {
+			seen[rgba] = true
+			palette = append(palette, rgba)
+		}
+	}
+
+	// Fill the remaining slots by popularity
+	extra := make([]color.RGBA, 0, len(counts))
+	for c := range counts {
+		if !seen[c] {
+			extra = append(extra, c)
+		}
+	}
+	sort.Slice(extra, func(i, j int) bool {
+		if counts[extra[i]] != counts[extra[j]] {
+			return counts[extra[i]] > counts[extra[j]]
+		}
+		// Keep the order deterministic for equally frequent colors
+		a, b := extra[i], extra[j]
+		return uint32(a.R)<<24|uint32(a.G)<<16|uint32(a.B)<<8|uint32(a.A) <
+			uint32(b.R)<<24|uint32(b.G)<<16|uint32(b.B)<<8|uint32(b.A)
+	})
+	for _, c := range extra {
+		if len(palette) == 256 {
+			break
+		}
+		palette = append(palette, c)
+	}
+	return palette
+}
+
+// Function to map every pixel of the image to its nearest palette entry,
+// caching the lookups since the number of distinct colors is small
+func quantize(img *image.RGBA, palette color.Palette) *image.Paletted {
+	bounds := img.Bounds()
+	paletted := image.NewPaletted(bounds, palette)
+	index := make(map[color.RGBA]uint8)
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			c := img.RGBAAt(x, y)
+			i, ok := index[c]
+			if !ok {
+				i = uint8(palette.Index(c))
+				index[c] = i
+			}
+			paletted.SetColorIndex(x, y, i)
+		}
+	}
+	return paletted
+}
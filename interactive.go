@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Function to draw the path of a prefecture. Interactive SVG output tags it
+// with an id and its intensity for scripts, along with a title shown as a
+// tooltip.
+func drawFeaturePath(canvas *svg.SVG, path, style string, feature *geojson.Feature, scale int, opts renderOptions) {
+	if !opts.interactive {
+		canvas.Path(path, style)
+		return
+	}
+
+	id := int(feature.Properties["id"].(float64))
+	label := scaleText(scale, opts.halves[id])
+	title := label
+	if name := featureName(feature); name != "" {
+		title = name + ": " + label
+	}
+	var escaped strings.Builder
+	xml.EscapeText(&escaped, []byte(title))
+
+	// svgo cannot nest elements in a path, so it is written directly
+	fmt.Fprintf(canvas.Writer, "<path id=\"prefecture-%d\" data-id=\"%d\" data-scale=\"%s\" d=\"%s\" style=\"%s\"><title>%s</title></path>\n",
+		id, id, label, path, style, escaped.String())
+}
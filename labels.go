@@ -0,0 +1,135 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	geojson "github.com/paulmach/go.geojson"
+	"golang.org/x/image/font"
+)
+
+// Name of a prefecture placed on the map, positioned by the left end of its
+// baseline
+type placedLabel struct {
+	text string
+	x, y float64
+}
+
+// Function to get the name of the feature, preferring the romanized one
+func featureName(feature *geojson.Feature) string {
+	for _, key := range []string{"name", "nam_ja"} {
+		if name, ok := feature.Properties[key].(string); ok && name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// Function to calculate the centroid of the largest polygon of the feature
+// from its outer ring. Degenerate rings fall back to the mean of the points.
+func featureCentroid(feature *geojson.Feature) (float64, float64, bool) {
+	var outer [][]float64
+	largest := -1.0
+	for _, polygon := range featurePolygons(feature) {
+		if len(polygon) == 0 || len(polygon[0]) == 0 {
+			continue
+		}
+		if area := math.Abs(ringArea(polygon[0])); area > largest {
+			outer, largest = polygon[0], area
+		}
+	}
+	if outer == nil {
+		return 0, 0, false
+	}
+
+	var area, cx, cy float64
+	for i, j := 0, len(outer)-1; i < len(outer); j, i = i, i+1 {
+		cross := outer[j][0]*outer[i][1] - outer[i][0]*outer[j][1]
+		area += cross
+		cx += (outer[j][0] + outer[i][0]) * cross
+		cy += (outer[j][1] + outer[i][1]) * cross
+	}
+	if math.Abs(area) < 1e-12 {
+		lon, lat := calculateCenter(outer)
+		return lon, lat, true
+	}
+	return cx / (3 * area), cy / (3 * area), true
+}
+
+// Function to calculate the signed area of the ring (shoelace formula)
+func ringArea(ring [][]float64) float64 {
+	var area float64
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		area += ring[j][0]*ring[i][1] - ring[i][0]*ring[j][1]
+	}
+	return area / 2
+}
+
+// Function to place the names of the affected prefectures centered on their
+// centroids. Labels are shifted back inside the canvas rather than clipped.
+func layoutLabels(f *truetype.Font, features []*geojson.Feature, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) []placedLabel {
+	face := truetype.NewFace(f, &truetype.Options{Size: opts.labelFontSize, DPI: 72})
+	defer face.Close()
+
+	margin := 4 * opts.multiplier
+	var labels []placedLabel
+	for _, feature := range features {
+		id := int(feature.Properties["id"].(float64))
+		name := featureName(feature)
+		if scaleMap[id] == 0 || name == "" {
+			continue
+		}
+		lon, lat, ok := featureCentroid(feature)
+		if !ok {
+			continue
+		}
+
+		cx, cy := funcToScreen(lon, lat)
+		if opts.showScale {
+			// Leave the center to the scale value
+			cy += opts.labelFontSize
+		}
+		width := float64(font.MeasureString(face, name)) / 64
+		x := cx - width/2
+		y := cy + opts.labelFontSize*0.35
+
+		x = math.Max(margin, math.Min(x, opts.mapWidth()-margin-width))
+		y = math.Max(margin+opts.labelFontSize*0.75, math.Min(y, float64(opts.height)-margin-opts.labelFontSize*0.25))
+		labels = append(labels, placedLabel{name, x, y})
+	}
+	return labels
+}
+
+// Function to draw the prefecture names as SVG text, used for the vector
+// output
+func drawLabelsSVG(canvas *svg.SVG, features []*geojson.Feature, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	style := fmt.Sprintf("fill:#fafafa;font-family:%s;font-size:%.1fpx", opts.fontFile().family, opts.labelFontSize)
+	for _, label := range layoutLabels(f, features, scaleMap, opts, funcToScreen) {
+		canvas.Text(int(label.x), int(label.y), label.text, style)
+	}
+	return nil
+}
+
+// Function to draw the prefecture names onto the rendered image
+func drawLabels(rgba *image.RGBA, features []*geojson.Feature, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetFontSize(opts.labelFontSize)
+	for _, label := range layoutLabels(f, features, scaleMap, opts, funcToScreen) {
+		if _, err := c.DrawString(label.text, freetype.Pt(int(label.x), int(label.y))); err != nil {
+			return fmt.Errorf("failed to draw label: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"strconv"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+)
+
+// Row of the legend, a swatch with the intensity next to it
+type legendRow struct {
+	scale int
+	y     float64 // Top of the swatch
+}
+
+// Placement of the legend on the image
+type legendLayout struct {
+	x, y, width, height float64
+	titleY              float64 // Baseline of the title
+	swatch              float64 // Side of the swatches
+	rows                []legendRow
+}
+
+// Function to get the intensities listed in the legend from top to bottom,
+// the highest first unless the order is "asc"
+func legendScales(order string) []int {
+	scales := make([]int, 0, 7)
+	for i := 0; i < 7; i++ {
+		if order == "asc" {
+			scales = append(scales, i+1)
+		} else {
+			scales = append(scales, 7-i)
+		}
+	}
+	return scales
+}
+
+// Function to lay out the legend in its corner, keeping clear of the other
+// overlays sharing it (the footer, the locator and the data age)
+func newLegendLayout(opts renderOptions) legendLayout {
+	m := opts.multiplier
+	pad, rowHeight := 8*m, 18*m
+	l := legendLayout{width: 72 * m, swatch: 12 * m}
+	l.height = 2*pad + 16*m + 7*rowHeight
+
+	margin := 16 * m
+	top, bottom := margin, margin+opts.footerClearance() // The footer runs along the bottom
+	if opts.locator && opts.legend == "topright" {
+		top += 120*m + margin
+	}
+	if opts.showDataAge && opts.legend == "topleft" {
+		top += 10*m + margin
+	}
+
+	l.x, l.y = margin, top
+	switch opts.legend {
+	case "topright":
+		l.x = opts.mapWidth() - margin - l.width
+	case "bottomleft":
+		l.y = float64(opts.height) - bottom - l.height
+	case "bottomright":
+		l.x = opts.mapWidth() - margin - l.width
+		l.y = float64(opts.height) - bottom - l.height
+	}
+
+	l.titleY = l.y + pad + 11*m
+	for i, scale := range legendScales(opts.legendOrder) {
+		l.rows = append(l.rows, legendRow{scale, l.y + pad + 16*m + float64(i)*rowHeight + (rowHeight-l.swatch)/2})
+	}
+	return l
+}
+
+// Function to get the fill of an intensity in the legend, following the
+// fill overrides of the request
+func (opts renderOptions) legendFill(scale int) string {
+	if fill, ok := opts.fills[scale]; ok {
+		return fill
+	}
+	return opts.intensityColor(scale)
+}
+
+// Function to draw the legend as SVG elements, used for the vector output
+func drawLegendSVG(canvas *svg.SVG, opts renderOptions) {
+	l := newLegendLayout(opts)
+	m := opts.multiplier
+	canvas.Rect(int(l.x), int(l.y), int(l.width), int(l.height),
+		fmt.Sprintf("fill:#18181b;fill-opacity:0.8;stroke:#3f3f46;stroke-width:%.2f", m))
+
+	textStyle := fmt.Sprintf("fill:#fafafa;font-family:%s;font-size:%.1fpx", opts.fontFile().family, 12*m)
+	canvas.Text(int(l.x+8*m), int(l.titleY), "Intensity", textStyle)
+	for _, row := range l.rows {
+		canvas.Rect(int(l.x+8*m), int(row.y), int(l.swatch), int(l.swatch),
+			fmt.Sprintf("fill:%s;fill-opacity:%g;stroke:%s;stroke-width:%.2f", opts.legendFill(row.scale), opts.fillOpacity, opts.strokeColor, opts.strokeWidth()))
+		if opts.pattern {
+			canvas.Rect(int(l.x+8*m), int(row.y), int(l.swatch), int(l.swatch), fmt.Sprintf("fill:url(#%s);stroke:none", hatchID(row.scale)))
+		}
+		canvas.Text(int(l.x+8*m+l.swatch+8*m), int(row.y+l.swatch-2*m), strconv.Itoa(row.scale), textStyle)
+	}
+}
+
+// Function to draw the legend onto the rendered image. Fills referencing
+// definitions cannot be drawn here and fall back to the palette.
+func drawLegend(img *image.RGBA, c *textRenderer, opts renderOptions) error {
+	l := newLegendLayout(opts)
+	m := opts.multiplier
+
+	fillRect := func(x, y, w, h float64, fill color.NRGBA) {
+		rect := image.Rect(int(x), int(y), int(x+w), int(y+h)).Intersect(img.Bounds())
+		for py := rect.Min.Y; py < rect.Max.Y; py++ {
+			for px := rect.Min.X; px < rect.Max.X; px++ {
+				blendPixel(img, px, py, fill)
+			}
+		}
+	}
+	fillRect(l.x, l.y, l.width, l.height, color.NRGBA{0x18, 0x18, 0x1b, 0xcc})
+
+	c.SetFontSize(12 * m)
+	if _, err := c.DrawString("Intensity", freetype.Pt(int(l.x+8*m), int(l.titleY))); err != nil {
+		return fmt.Errorf("failed to draw legend title: %w", err)
+	}
+	for _, row := range l.rows {
+		fill, err := hexToRGBA(opts.legendFill(row.scale))
+		if err != nil {
+			fill, _ = hexToRGBA(opts.intensityColor(row.scale))
+		}
+		inset := 0.0
+		if opts.strokeWidth() > 0 {
+			stroke, _ := hexToRGBA(opts.strokeColor)
+			fillRect(l.x+8*m, row.y, l.swatch, l.swatch, color.NRGBA{stroke.R, stroke.G, stroke.B, 0xff})
+			inset = max(1, opts.strokeWidth())
+		}
+		fillRect(l.x+8*m+inset, row.y+inset, l.swatch-2*inset, l.swatch-2*inset, color.NRGBA{0x18, 0x18, 0x1b, 0xff})
+		fillRect(l.x+8*m+inset, row.y+inset, l.swatch-2*inset, l.swatch-2*inset, color.NRGBA{fill.R, fill.G, fill.B, uint8(opts.fillOpacity*255 + 0.5)})
+		if opts.pattern {
+			swatch := image.Rect(int(l.x+8*m+inset), int(row.y+inset), int(l.x+8*m+l.swatch-inset), int(row.y+l.swatch-inset))
+			fillHatch(img, swatch, hatches[row.scale], opts.hatchColor(row.scale), m, func(x, y int) uint8 { return 0xff })
+		}
+
+		pt := freetype.Pt(int(l.x+8*m+l.swatch+8*m), int(row.y+l.swatch-2*m))
+		if _, err := c.DrawString(strconv.Itoa(row.scale), pt); err != nil {
+			return fmt.Errorf("failed to draw legend label: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Simplified continent outlines (lon, lat) used as context on the locator
+// globe. Only the parts that are visible from East Asia are detailed.
+var locatorLand = [][][2]float64{
+	// Eurasia
+	{
+		{32, 30}, {43, 13}, {52, 16}, {57, 23}, {67, 25}, {73, 20}, {77, 8}, {80, 13},
+		{87, 21}, {94, 17}, {98, 10}, {104, 1}, {103, 5}, {100, 13}, {105, 9},
+		{109, 12}, {106, 20}, {110, 21}, {114, 22}, {117, 24}, {120, 28}, {122, 31},
+		{122, 37}, {119, 38}, {121, 41}, {125, 38}, {126, 34.5}, {129, 35}, {130, 42},
+		{135, 44}, {140, 48}, {141, 53}, {137, 54}, {143, 59}, {155, 59}, {156, 51},
+		{163, 56}, {163, 62}, {180, 65}, {190, 66}, {180, 70}, {140, 72}, {113, 73},
+		{100, 77}, {80, 73}, {60, 69}, {40, 67}, {40, 60}, {35, 40},
+	},
+	// Africa
+	{
+		{32, 31}, {43, 12}, {51, 12}, {40, -3}, {40, -15}, {35, -25}, {20, -35},
+		{12, -17}, {9, 4}, {-17, 15}, {-10, 30}, {10, 37},
+	},
+	// Australia
+	{
+		{114, -22}, {114, -34}, {118, -35}, {123, -34}, {131, -31.5}, {138, -35},
+		{141, -38}, {146, -39}, {150, -37}, {153, -32}, {153, -25}, {146, -19},
+		{142, -11}, {141, -17}, {136, -12}, {131, -11}, {126, -14}, {122, -18},
+	},
+	// North America
+	{
+		{-166, 69}, {-163, 60}, {-158, 57}, {-150, 61}, {-140, 60}, {-133, 55},
+		{-125, 49}, {-124, 42}, {-120, 34}, {-110, 23}, {-105, 20}, {-95, 16},
+		{-83, 9}, {-77, 8}, {-80, 25}, {-75, 35}, {-60, 47}, {-65, 60}, {-80, 63},
+		{-95, 70}, {-125, 70}, {-141, 70}, {-156, 71},
+	},
+	// Borneo
+	{{109, 1}, {110, -2}, {116, -4}, {119, 1}, {117, 7}, {113, 3}},
+	// Sumatra
+	{{95, 5}, {98, 4}, {106, -6}, {102, -4}, {98, 0}},
+	// New Guinea
+	{{131, -1}, {141, -3}, {150, -10}, {144, -8}, {138, -8}, {132, -4}},
+	// Philippines
+	{{120, 18}, {122.5, 18.5}, {124, 12}, {126, 7}, {125, 6}, {122, 7}, {120, 10}, {120, 15}},
+}
+
+// Function to draw a small globe centered on the map with the map's features
+// highlighted, giving global context to the regional map
+func drawLocator(canvas *svg.SVG, fc *geojson.FeatureCollection, cx, cy, radius, multiplier float64) {
+	// Center the globe on the whole map, independent of the affected area
+	minLon, minLat, maxLon, maxLat := 180.0, 90.0, -180.0, -90.0
+	for _, feature := range fc.Features {
+		for _, ring := range featureRings(feature) {
+			for _, coord := range ring {
+				minLon = min(minLon, coord[0])
+				minLat = min(minLat, coord[1])
+				maxLon = max(maxLon, coord[0])
+				maxLat = max(maxLat, coord[1])
+			}
+		}
+	}
+	lon0 := (minLon + maxLon) / 2 * math.Pi / 180
+	lat0 := (minLat + maxLat) / 2 * math.Pi / 180
+
+	// Orthographic projection, points on the far side are pushed to the limb
+	project := func(lon, lat float64) (x, y float64, visible bool) {
+		lambda := lon*math.Pi/180 - lon0
+		phi := lat * math.Pi / 180
+		px := math.Cos(phi) * math.Sin(lambda)
+		py := math.Cos(lat0)*math.Sin(phi) - math.Sin(lat0)*math.Cos(phi)*math.Cos(lambda)
+		visible = math.Sin(lat0)*math.Sin(phi)+math.Cos(lat0)*math.Cos(phi)*math.Cos(lambda) >= 0
+		if !visible {
+			if d := math.Hypot(px, py); d > 0 {
+				px, py = px/d, py/d
+			}
+		}
+		return cx + px*radius, cy - py*radius, visible
+	}
+
+	ringPath := func(coords [][2]float64) string {
+		var sb strings.Builder
+		for i, coord := range coords {
+			x, y, _ := project(coord[0], coord[1])
+			if i == 0 {
+				fmt.Fprintf(&sb, "M%.1f %.1f", x, y)
+			} else {
+				fmt.Fprintf(&sb, " L%.1f %.1f", x, y)
+			}
+		}
+		sb.WriteString(" Z")
+		return sb.String()
+	}
+
+	strokeWidth := 0.5 * multiplier
+	canvas.Circle(int(cx), int(cy), int(radius), "fill:#0c4a6e;stroke:none")
+
+	// Graticule every 30 degrees, only the visible segments are drawn
+	var graticule strings.Builder
+	line := func(points [][2]float64) {
+		pen := false
+		for _, p := range points {
+			x, y, visible := project(p[0], p[1])
+			if !visible {
+				pen = false
+				continue
+			}
+			if pen {
+				fmt.Fprintf(&graticule, " L%.1f %.1f", x, y)
+			} else {
+				fmt.Fprintf(&graticule, " M%.1f %.1f", x, y)
+				pen = true
+			}
+		}
+	}
+	for lon := -180.0; lon < 180; lon += 30 {
+		var points [][2]float64
+		for lat := -90.0; lat <= 90; lat += 5 {
+			points = append(points, [2]float64{lon, lat})
+		}
+		line(points)
+	}
+	for lat := -60.0; lat <= 60; lat += 30 {
+		var points [][2]float64
+		for lon := -180.0; lon <= 180; lon += 5 {
+			points = append(points, [2]float64{lon, lat})
+		}
+		line(points)
+	}
+	if graticule.Len() > 0 {
+		canvas.Path(graticule.String(), fmt.Sprintf("fill:none;stroke:#38bdf8;stroke-opacity:0.3;stroke-width:%.1f", strokeWidth))
+	}
+
+	for _, land := range locatorLand {
+		canvas.Path(ringPath(land), "fill:#52525b;stroke:none")
+	}
+
+	// Highlight the features of the map, decimated since they are tiny here
+	var highlight strings.Builder
+	for _, feature := range fc.Features {
+		for _, ring := range featureRings(feature) {
+			step := len(ring) / 20
+			if step < 1 {
+				step = 1
+			}
+			var coords [][2]float64
+			for i := 0; i < len(ring); i += step {
+				coords = append(coords, [2]float64{ring[i][0], ring[i][1]})
+			}
+			if len(coords) >= 3 {
+				highlight.WriteString(ringPath(coords) + " ")
+			}
+		}
+	}
+	canvas.Path(highlight.String(), "fill:#dc2626;stroke:none")
+
+	// Mark the map area so it stays visible even when it is very small
+	x, y, _ := project((minLon+maxLon)/2, (minLat+maxLat)/2)
+	canvas.Circle(int(x), int(y), int(radius*0.3),
+		fmt.Sprintf("fill:none;stroke:#fafafa;stroke-width:%.1f", strokeWidth*2))
+
+	canvas.Circle(int(cx), int(cy), int(radius),
+		fmt.Sprintf("fill:none;stroke:#a1a1aa;stroke-width:%.1f", strokeWidth*2))
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Prefecture matched by a lookup
+type LookupMatch struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Function to check whether the point lies inside the ring (even-odd rule)
+func ringContains(ring [][]float64, lon, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		xi, yi := ring[i][0], ring[i][1]
+		xj, yj := ring[j][0], ring[j][1]
+		if (yi > lat) != (yj > lat) && lon < (xj-xi)*(lat-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
+
+// Function to check whether the point lies inside the polygon, outside of
+// its holes
+func polygonContains(polygon [][][]float64, lon, lat float64) bool {
+	if len(polygon) == 0 || !ringContains(polygon[0], lon, lat) {
+		return false
+	}
+	for _, hole := range polygon[1:] {
+		if ringContains(hole, lon, lat) {
+			return false
+		}
+	}
+	return true
+}
+
+// Function to check whether the point lies inside the feature. Western
+// longitudes are also tried past 180°, where unwrapAntimeridian moves the
+// features crossing the antimeridian.
+func featureContains(feature *geojson.Feature, lon, lat float64) bool {
+	for _, polygon := range featurePolygons(feature) {
+		if polygonContains(polygon, lon, lat) || (lon < 0 && polygonContains(polygon, lon+360, lat)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to check whether the segments ab and cd cross each other
+func segmentsIntersect(a, b, c, d []float64) bool {
+	cross := func(o, p, q []float64) float64 {
+		return (p[0]-o[0])*(q[1]-o[1]) - (p[1]-o[1])*(q[0]-o[0])
+	}
+	d1, d2 := cross(c, d, a), cross(c, d, b)
+	d3, d4 := cross(a, b, c), cross(a, b, d)
+	return ((d1 > 0) != (d2 > 0)) && ((d3 > 0) != (d4 > 0))
+}
+
+// Function to check whether the feature overlaps the query polygon, either
+// by containing one another or by crossing borders
+func featureIntersects(feature *geojson.Feature, query [][]float64) bool {
+	if len(query) > 0 && featureContains(feature, query[0][0], query[0][1]) {
+		return true
+	}
+
+	// Bounds of the query, used to skip the rings that cannot cross it
+	minLon, minLat, maxLon, maxLat := math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)
+	for _, p := range query {
+		minLon, minLat = math.Min(minLon, p[0]), math.Min(minLat, p[1])
+		maxLon, maxLat = math.Max(maxLon, p[0]), math.Max(maxLat, p[1])
+	}
+
+	for _, ring := range featureRings(feature) {
+		for _, p := range ring {
+			if ringContains(query, p[0], p[1]) {
+				return true
+			}
+		}
+		for i := 1; i < len(ring); i++ {
+			a, b := ring[i-1], ring[i]
+			if math.Max(a[0], b[0]) < minLon || math.Min(a[0], b[0]) > maxLon ||
+				math.Max(a[1], b[1]) < minLat || math.Min(a[1], b[1]) > maxLat {
+				continue
+			}
+			for j, k := 0, len(query)-1; j < len(query); k, j = j, j+1 {
+				if segmentsIntersect(a, b, query[k], query[j]) {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// Resolves a point (lon, lat) or a polygon (JSON array of [lon, lat]) to the
+// prefectures it falls in
+func lookupHandler(w http.ResponseWriter, r *http.Request) {
+	var match func(feature *geojson.Feature) bool
+	if polygonData := r.URL.Query().Get("polygon"); polygonData != "" {
+		var polygon [][]float64
+		if err := json.Unmarshal([]byte(polygonData), &polygon); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid polygon data format: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(polygon) < 3 || len(polygon) > 1000 {
+			http.Error(w, "polygon must have between 3 and 1000 points", http.StatusBadRequest)
+			return
+		}
+		for _, p := range polygon {
+			if len(p) != 2 {
+				http.Error(w, "polygon points must be [lon, lat] pairs", http.StatusBadRequest)
+				return
+			}
+		}
+		match = func(feature *geojson.Feature) bool { return featureIntersects(feature, polygon) }
+	} else {
+		lon, errLon := strconv.ParseFloat(r.URL.Query().Get("lon"), 64)
+		lat, errLat := strconv.ParseFloat(r.URL.Query().Get("lat"), 64)
+		if errLon != nil || errLat != nil || math.Abs(lon) > 180 || math.Abs(lat) > 90 {
+			http.Error(w, "lon and lat parameters or a polygon are required", http.StatusBadRequest)
+			return
+		}
+		match = func(feature *geojson.Feature) bool { return featureContains(feature, lon, lat) }
+	}
+
+	base, ok := selectBaseMap(r.URL.Query().Get("map"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid map value: %s", r.URL.Query().Get("map")), http.StatusBadRequest)
+		return
+	}
+
+	matches := []LookupMatch{}
+	for _, feature := range base.features.Features {
+		id, ok := feature.Properties["id"].(float64)
+		if !ok || !match(feature) {
+			continue
+		}
+		name, _ := feature.Properties["name"].(string)
+		matches = append(matches, LookupMatch{ID: int(id), Name: name})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]LookupMatch{"prefectures": matches})
+}
@@ -2,27 +2,71 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"log"
 	"math"
+	"mime"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
 	svg "github.com/ajstarks/svgo"
 	"github.com/golang/freetype"
 	"github.com/golang/freetype/truetype"
 	geojson "github.com/paulmach/go.geojson"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/srwiley/oksvg"
 	"github.com/srwiley/rasterx"
+	"golang.org/x/image/draw"
 )
 
 type IntensityQuery struct {
-	ID    int `json:"id"`
-	Scale int `json:"scale"`
+	ID        int    `json:"id"`
+	Scale     int    `json:"scale"`
+	Uncertain bool   `json:"uncertain,omitempty"`
+	History   []int  `json:"history,omitempty"` // Earlier intensities, oldest first
+	Color     string `json:"color,omitempty"`   // Fill overriding the color of the intensity (#rrggbb)
+
+	half int // Half of levels 5 and 6 (lowerHalf, upperHalf), 0 when undivided
+}
+
+// Function to parse the intensities, given either as a JSON array or as
+// compact id:scale pairs separated by commas (e.g. 13:5,14:3)
+func parseIntensities(data string) ([]IntensityQuery, error) {
+	var intensities []IntensityQuery
+	if strings.HasPrefix(strings.TrimSpace(data), "[") {
+		err := json.Unmarshal([]byte(data), &intensities)
+		return intensities, err
+	}
+
+	for _, pair := range strings.Split(data, ",") {
+		id, scale, found := strings.Cut(strings.TrimSpace(pair), ":")
+		if !found {
+			return nil, fmt.Errorf("expected id:scale, got %q", pair)
+		}
+		var intensity IntensityQuery
+		var errID, errScale error
+		intensity.ID, errID = strconv.Atoi(id)
+		intensity.Scale, intensity.half, errScale = parseScale(scale)
+		if errID != nil || errScale != nil {
+			return nil, fmt.Errorf("expected id:scale, got %q", pair)
+		}
+		intensities = append(intensities, intensity)
+	}
+	return intensities, nil
 }
 
 // Function to convert intensity scale to color
@@ -45,81 +89,77 @@ func intensityToColor(scale int) string {
 	case 7:
 		return "#500724"
 	default:
-		if scale > 6 {
-			return "#4a044e"
-		}
-		if scale > 5 {
-			return "#b91c1c"
+		// Above the scale keeps the strongest color, below zero stays neutral
+		if scale > 7 {
+			return "#500724"
 		}
 		return "#27272a"
 	}
 }
 
+// Function to load the Roboto font of the given weight
 func loadFont(weight int) (*truetype.Font, error) {
-	var fontPath string
 	switch weight {
-	case 400:
-		fontPath = "./fonts/roboto-regular.ttf"
 	case 500:
-		fontPath = "./fonts/roboto-medium.ttf"
+		return loadFontFile(fontFiles["roboto-medium"].file)
 	default:
-		fontPath = "./fonts/roboto-regular.ttf" // default to regular
-	}
-
-	fontBytes, err := os.ReadFile(fontPath)
-	if err != nil {
-		return nil, err
-	}
-	f, err := freetype.ParseFont(fontBytes)
-	if err != nil {
-		return nil, err
+		return loadFontFile(fontFiles["roboto"].file) // default to regular
 	}
-	return f, nil
 }
 
-// Function to calculate the drawing range
+// Function to calculate the drawing range. When no prefecture has a nonzero
+// scale, the range of the whole map is returned.
 func calculateBounds(fc *geojson.FeatureCollection, scaleMap map[int]int) (minLon, minLat, maxLon, maxLat float64) {
 	minLon = 180.0
 	minLat = 90.0
 	maxLon = -180.0
 	maxLat = -90.0
 
+	affected := false
+	for _, feature := range fc.Features {
+		if scaleMap[int(feature.Properties["id"].(float64))] != 0 {
+			affected = true
+			break
+		}
+	}
+
 	for _, feature := range fc.Features {
 		// Skip if the scale is 0 (transparent prefectures are not calculated)
 		id := int(feature.Properties["id"].(float64))
-		if scaleMap[id] == 0 {
+		if affected && scaleMap[id] == 0 {
 			continue
 		}
 
-		// Calculate the range from the coordinates of the polygon
-		switch feature.Geometry.Type {
-		case "Polygon":
-			for _, ring := range feature.Geometry.Polygon {
-				for _, coord := range ring {
-					lon, lat := coord[0], coord[1]
-					minLon = min(minLon, lon)
-					minLat = min(minLat, lat)
-					maxLon = max(maxLon, lon)
-					maxLat = max(maxLat, lat)
-				}
-			}
-		case "MultiPolygon":
-			for _, polygon := range feature.Geometry.MultiPolygon {
-				for _, ring := range polygon {
-					for _, coord := range ring {
-						lon, lat := coord[0], coord[1]
-						minLon = min(minLon, lon)
-						minLat = min(minLat, lat)
-						maxLon = max(maxLon, lon)
-						maxLat = max(maxLat, lat)
-					}
-				}
+		// Calculate the range from the coordinates of the polygons
+		for _, ring := range featureRings(feature) {
+			for _, coord := range ring {
+				lon, lat := coord[0], coord[1]
+				minLon = min(minLon, lon)
+				minLat = min(minLat, lat)
+				maxLon = max(maxLon, lon)
+				maxLat = max(maxLat, lat)
 			}
 		}
 	}
 	return
 }
 
+// Function to get the features with a nonzero intensity, falling back to all
+// features when none is affected like calculateBounds. The features are
+// shared with fc, only the collection is new.
+func affectedFeatures(fc *geojson.FeatureCollection, scaleMap map[int]int) *geojson.FeatureCollection {
+	affected := geojson.NewFeatureCollection()
+	for _, feature := range fc.Features {
+		if scaleMap[int(feature.Properties["id"].(float64))] != 0 {
+			affected.AddFeature(feature)
+		}
+	}
+	if len(affected.Features) == 0 {
+		return fc
+	}
+	return affected
+}
+
 func calculateCenter(coords [][]float64) (float64, float64) {
 	var sumLon, sumLat float64
 	count := len(coords)
@@ -132,45 +172,403 @@ func calculateCenter(coords [][]float64) (float64, float64) {
 	return sumLon / float64(count), sumLat / float64(count)
 }
 
-// Function to convert SVG data to PNG
-func svgToPNG(svgData []byte, width, height int, footerText string, showScale bool, multiplier float64, features []*geojson.Feature, scaleMap map[int]int, funcToScreen func(float64, float64) (float64, float64)) ([]byte, error) {
-	// Loading SVG data
-	icon, err := oksvg.ReadIconStream(bytes.NewReader(svgData))
+// Function to remove the features without any geometry (null, or polygons
+// without points), which would otherwise end up as empty paths. The IDs of
+// the removed features are returned.
+func removeEmptyFeatures(fc *geojson.FeatureCollection) []int {
+	var empty []int
+	features := fc.Features[:0]
+	for _, feature := range fc.Features {
+		points := 0
+		polygons := geometryPolygons(feature.Geometry, func(geometryType string) {
+			log.Printf("skipping %s geometry of feature %v, only polygons are drawn", geometryType, feature.Properties["id"])
+		})
+		for _, polygon := range polygons {
+			for _, ring := range polygon {
+				points += len(ring)
+			}
+		}
+		if points > 0 {
+			features = append(features, feature)
+			continue
+		}
+
+		id, _ := feature.Properties["id"].(float64)
+		log.Printf("skipping feature %v without geometry", feature.Properties["id"])
+		empty = append(empty, int(id))
+	}
+	fc.Features = features
+	return empty
+}
+
+// Options controlling how the map is rendered
+type renderOptions struct {
+	width      int
+	height     int
+	multiplier float64
+	tolerance  float64 // Distance in pixels under which ring points are merged
+	locator    bool
+	footerText string
+	showScale  bool
+	indexed    bool
+	fadeWidth  float64 // Width of the edge fade in pixels (0 disables it)
+	fadeMode   string
+	smooth     bool // Anti-aliasing of the shapes
+	textSmooth bool // Anti-aliasing of the text
+	strokeMin  float64
+	strokeMax  float64
+	qrURL      string  // URL encoded into a QR code (empty disables it)
+	qrSize     float64 // Size of the QR code in pixels
+	qrPosition string
+	bathymetry bool         // Tint the sea with a depth-like gradient
+	halo       bool         // Draw a blurred halo around uncertain prefectures
+	uncertain  map[int]bool // IDs whose intensity is flagged as uncertain
+
+	strokeAffectedOnly bool    // Only draw the borders of prefectures with nonzero intensity
+	cornerRadius       float64 // Radius of the rounded corners in pixels (0 keeps them square)
+	labelHalo          bool    // Outline the text for legibility over the fills
+	labelHaloColor     color.RGBA
+	footerSize         float64 // Font size of the footer in points
+	footerColor        color.RGBA
+	projection         planarProjection // nil keeps the default equirectangular projection
+	showDataAge        bool
+	dataAge            time.Duration // Time since the data was produced
+	dataAgeWarn        time.Duration // Age from which the data is flagged as aging
+	dataAgeStale       time.Duration // Age from which the data is flagged as stale
+	defs               string        // Sanitized SVG definitions supplied by the client
+	fills              map[int]string
+	history            map[int][]int // Earlier intensities by ID, drawn when sparklines is set
+	sparklines         bool
+	maxBytes           int // Size budget of the encoded output (0 for none)
+	quality            int // Quality of lossy output (1-100)
+	lossless           bool
+	palette            palette // Colors of the intensities, nil for the default
+	showCrosshair      bool
+	crosshair          [2]float64 // Position of the crosshair (lon, lat)
+	crosshairRings     []float64  // Radii of the distance rings in kilometers
+	generalize         float64    // Radius in pixels under which coastline detail is smoothed out
+	font               string     // Name of the font in fontFiles
+	legend             string     // Corner of the intensity legend, empty for none
+	vector             bool       // Output is SVG, so text overlays are drawn as SVG elements
+	labels             bool
+	labelFontSize      float64 // Font size of the prefecture names in pixels
+	showEpicenter      bool
+	epicenter          [2]float64 // Position of the epicenter (lon, lat)
+	epicenterDepth     float64    // Depth of the hypocenter in kilometers, negative when unknown
+	center             [2]float64 // Center of the bounds (lon, lat)
+	showScaleBar       bool
+	graticule          bool
+	graticuleStep      float64     // Spacing of the graticule lines in degrees
+	halves             map[int]int // Half of levels 5 and 6 by ID, see scaleColor
+	supersample        int         // Factor of the resolution the shapes are rasterized at
+	background         color.RGBA  // Zero alpha for a transparent background
+	margin             float64     // Share of the canvas left empty on each side of the bounds
+	borderWidth        float64     // Width of the prefecture borders in pixels, negative to follow the output scale
+	strokeColor        string      // Color of the prefecture borders (#rrggbb)
+	fillOpacity        float64     // Opacity of the intensity fills
+	outline            bool        // Outline the affected prefectures in their color instead of filling them
+	outlineWidth       float64     // Width of the outlines in pixels
+	dpi                float64     // Resolution of the footer text, 72 keeps points and pixels equal
+	timestamp          time.Time   // Time of the render shown in the footer, zero for none
+	pattern            bool        // Hatch the affected prefectures by intensity
+	compression        png.CompressionLevel
+	rotate             int  // Clockwise rotation of the map in degrees (0, 90, 180 or 270)
+	interactive        bool // Tag the prefectures of the SVG output for scripts
+
+	// Features drawn muted under the affected prefectures, nil for none
+	baseLayer []*geojson.Feature
+
+	// Features whose unaffected prefectures are drawn as outlines, nil for none
+	contextLayer []*geojson.Feature
+
+	// Prefectures by intensity (1-7) shown in a sidebar, nil for none
+	histogram []int
+
+	// Fills of single prefectures by ID, over the colors of their intensity
+	colors map[int]string
+
+	// Keep center in the middle of the canvas instead of the middle of the
+	// bounds, the scale still fits the bounds
+	recenter bool
+
+	// Order of the legend entries, "desc" (highest first) or "asc"
+	legendOrder string
+}
+
+// Function to get the width of the prefecture borders in output pixels. The
+// width follows the output scale but is clamped so that borders neither
+// vanish on small renders nor get heavy on large ones, unless the request
+// sets the width.
+func (opts renderOptions) strokeWidth() float64 {
+	if opts.borderWidth >= 0 {
+		return opts.borderWidth
+	}
+	return math.Max(opts.strokeMin, math.Min(opts.strokeMax, 0.4*opts.multiplier))
+}
+
+// Function to draw the map as SVG
+func buildSVG(fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	canvas := svg.New(buf)
+	canvas.Start(opts.width, opts.height)
+	if err := drawMap(canvas, fc, scaleMap, opts, funcToScreen); err != nil {
+		return nil, err
+	}
+	canvas.End()
+	return buf.Bytes(), nil
+}
+
+// Function to draw the map as an SVG <symbol> with the given id, so that many
+// maps can be combined into one sprite and placed with <use>
+func buildSymbol(fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64), id string) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	canvas := svg.New(buf)
+	fmt.Fprintf(canvas.Writer, "<symbol id=\"%s\" viewBox=\"0 0 %d %d\">\n", id, opts.width, opts.height)
+	if err := drawMap(canvas, fc, scaleMap, opts, funcToScreen); err != nil {
+		return nil, err
+	}
+	fmt.Fprintln(canvas.Writer, "</symbol>")
+	return buf.Bytes(), nil
+}
+
+// Function to get the fill of a prefecture, the color of its intensity
+// unless the client supplied a fill for the prefecture or that intensity
+func (opts renderOptions) fillColor(id, scale int) string {
+	if fill, ok := opts.colors[id]; ok {
+		return fill
+	}
+	if fill, ok := opts.fills[scale]; ok {
+		return fill
+	}
+	return opts.scaleColor(scale, opts.halves[id])
+}
+
+// Color of the unaffected prefectures with context=outline, muted on both the
+// dark and the light theme
+const contextOutlineColor = "#71717a"
+
+// Function to draw the elements of the map onto the canvas
+func drawMap(canvas *svg.SVG, fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	if fill := opts.backgroundFill(); fill != "" {
+		canvas.Rect(0, 0, opts.width, opts.height, "fill:"+fill)
+	}
+
+	hatched := opts.pattern && opts.vector
+	if opts.defs != "" || hatched {
+		// Definitions go first since oksvg resolves references while reading
+		canvas.Def()
+		io.WriteString(canvas.Writer, opts.defs)
+		if hatched {
+			io.WriteString(canvas.Writer, hatchPatterns(opts))
+		}
+		canvas.DefEnd()
+	}
+
+	for _, feature := range fc.Features {
+		if _, ok := feature.Properties["id"].(float64); !ok {
+			return errors.New("invalid ID format in GeoJSON")
+		}
+	}
+
+	if opts.baseLayer != nil {
+		// Geographic context, the unaffected prefectures of the map are
+		// left to this layer
+		style := fmt.Sprintf("fill:%s;fill-opacity:%g;stroke:%s;stroke-opacity:0.5;stroke-width:%.2f",
+			opts.intensityColor(0), opts.fillOpacity/2, opts.strokeColor, opts.strokeWidth())
+		if opts.strokeWidth() == 0 {
+			style = fmt.Sprintf("fill:%s;fill-opacity:%g;stroke:none", opts.intensityColor(0), opts.fillOpacity/2)
+		}
+		for _, path := range featurePaths(opts.baseLayer, funcToScreen, opts.tolerance) {
+			canvas.Path(path, style)
+		}
+	}
+
+	if opts.contextLayer != nil {
+		// Coastlines hinting at the surroundings without competing with the
+		// fills, the unaffected prefectures are left to this layer
+		var unaffected []*geojson.Feature
+		for _, feature := range opts.contextLayer {
+			if scaleMap[int(feature.Properties["id"].(float64))] == 0 {
+				unaffected = append(unaffected, feature)
+			}
+		}
+		style := fmt.Sprintf("fill:none;stroke:%s;stroke-width:%.2f;stroke-linejoin:round",
+			contextOutlineColor, math.Max(opts.strokeMin, opts.strokeWidth()))
+		for _, path := range featurePaths(unaffected, funcToScreen, opts.tolerance) {
+			canvas.Path(path, style)
+		}
+	}
+
+	paths := featurePaths(fc.Features, funcToScreen, opts.tolerance)
+	// Outlines go on top of the other borders so that shared edges keep the
+	// color of the intensity
+	var outlines []int
+	outlineStyles := make(map[int]string)
+	for i, feature := range fc.Features {
+		id := feature.Properties["id"].(float64)
+
+		scaleValue := 0
+		if val, ok := scaleMap[int(id)]; ok {
+			scaleValue = val
+		}
+		if (opts.baseLayer != nil || opts.contextLayer != nil) && scaleValue == 0 {
+			continue
+		}
+		fillColor := opts.fillColor(int(id), scaleValue)
+
+		if opts.outline {
+			if scaleValue != 0 {
+				outlines = append(outlines, i)
+				outlineStyles[i] = fmt.Sprintf("fill:none;stroke:%s;stroke-width:%.2f;stroke-linejoin:round", fillColor, opts.outlineWidth)
+				continue
+			}
+			fillColor = "none"
+		}
+
+		style := fmt.Sprintf("fill:%s;stroke:%s;stroke-width:%.2f;fill-opacity:%g",
+			fillColor, opts.strokeColor, opts.strokeWidth(), opts.fillOpacity)
+		if opts.strokeWidth() == 0 || (opts.strokeAffectedOnly && scaleValue == 0) {
+			// Leave the borders of unaffected prefectures out to reduce noise
+			style = fmt.Sprintf("fill:%s;stroke:none;fill-opacity:%g", fillColor, opts.fillOpacity)
+		}
+		drawFeaturePath(canvas, paths[i], style, feature, scaleValue, opts)
+	}
+	if hatched {
+		drawHatchingSVG(canvas, fc.Features, paths, scaleMap)
+	}
+	for _, i := range outlines {
+		id := int(fc.Features[i].Properties["id"].(float64))
+		drawFeaturePath(canvas, paths[i], outlineStyles[i], fc.Features[i], scaleMap[id], opts)
+	}
+
+	if opts.graticule {
+		drawGraticule(canvas, opts, funcToScreen)
+	}
+
+	if opts.showCrosshair {
+		drawCrosshair(canvas, opts.crosshair[0], opts.crosshair[1], opts.crosshairRings, opts.multiplier, funcToScreen)
+	}
+
+	if opts.showEpicenter {
+		drawEpicenter(canvas, opts, funcToScreen)
+	}
+
+	if opts.sparklines {
+		drawSparklines(canvas, fc.Features, scaleMap, opts.history, opts, funcToScreen)
+	}
+
+	if opts.labels && opts.vector {
+		if err := drawLabelsSVG(canvas, fc.Features, scaleMap, opts, funcToScreen); err != nil {
+			return err
+		}
+	}
+
+	if opts.showScaleBar {
+		drawScaleBar(canvas, opts, funcToScreen)
+	}
+
+	if opts.legend != "" && opts.vector {
+		drawLegendSVG(canvas, opts)
+	}
+
+	if opts.histogram != nil && opts.vector {
+		drawHistogramSVG(canvas, opts)
+	}
+
+	if opts.locator {
+		// Inset globe in the top right corner
+		radius := 60 * opts.multiplier
+		margin := 16 * opts.multiplier
+		drawLocator(canvas, fc, opts.mapWidth()-margin-radius, margin+radius, radius, opts.multiplier)
+	}
+	return nil
+}
+
+// Function to render the map into an RGBA image
+func renderImage(fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) (*image.RGBA, error) {
+	svgData, err := buildSVG(fc, scaleMap, opts, funcToScreen)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read icon stream: %w", err)
+		return nil, err
+	}
+	rgba, err := svgToImage(svgData, opts, fc.Features, scaleMap, funcToScreen)
+	if err != nil {
+		return nil, err
+	}
+	if err := decorateImage(rgba, opts); err != nil {
+		return nil, err
 	}
+	return rgba, nil
+}
 
-	// Drawing Area Settings
+// Function to rasterize the SVG icon into an image of the given size
+func rasterizeIcon(icon *oksvg.SvgIcon, width, height int, smooth bool) *image.RGBA {
 	icon.SetTarget(0, 0, float64(width), float64(height))
 
-	// Creating RGBA images for drawing
 	rgba := image.NewRGBA(image.Rect(0, 0, width, height))
-	scanner := rasterx.NewScannerGV(width, height, rgba, rgba.Bounds())
+	var scanner rasterx.Scanner = rasterx.NewScannerGV(width, height, rgba, rgba.Bounds())
+	if !smooth {
+		scanner = newAliasedScanner(width, height, rgba)
+	}
 	raster := rasterx.NewDasher(width, height, scanner)
-
-	// SVG rendering
 	icon.Draw(raster, 1.0)
+	return rgba
+}
 
-	if footerText == "" {
-		footerText = "Code available under the MIT License (GitHub: evacuate)."
-	}
+// Function to convert SVG data to an image, drawing the scale values on top
+func svgToImage(svgData []byte, opts renderOptions, features []*geojson.Feature, scaleMap map[int]int, funcToScreen func(float64, float64) (float64, float64)) (*image.RGBA, error) {
+	width, height := opts.width, opts.height
 
-	// Load the font
-	f, err := loadFont(400)
+	// Loading SVG data
+	icon, err := oksvg.ReadIconStream(bytes.NewReader(svgData))
 	if err != nil {
-		return nil, fmt.Errorf("failed to load font: %w", err)
+		return nil, fmt.Errorf("failed to read icon stream: %w", err)
 	}
 
-	// Context for scale value text drawing
-	c := freetype.NewContext()
-	c.SetDPI(72)
-	c.SetFont(f)
-	c.SetFontSize(14 * multiplier)
-	c.SetClip(rgba.Bounds())
-	c.SetDst(rgba)
-	c.SetSrc(image.NewUniform(color.RGBA{0xfa, 0xfa, 0xfa, 0xff}))
+	// Shapes are rasterized at a multiple of the size when supersampling,
+	// the text below is drawn after scaling back down so it stays crisp
+	rgba := rasterizeIcon(icon, width*opts.supersample, height*opts.supersample, opts.smooth)
+	if opts.supersample > 1 {
+		large := rgba
+		rgba = image.NewRGBA(image.Rect(0, 0, width, height))
+		draw.CatmullRom.Scale(rgba, rgba.Bounds(), large, large.Bounds(), draw.Src, nil)
+	}
+
+	if opts.generalize > 0 || opts.bathymetry {
+		land, err := renderFeatureMask(features, opts, funcToScreen)
+		if err != nil {
+			return nil, err
+		}
+		if opts.generalize > 0 {
+			land = generalizeLand(rgba, land, int(opts.generalize), opts.background)
+		}
+		if opts.bathymetry {
+			applySeaGradient(rgba, land, int(40*opts.multiplier)+1)
+		}
+	}
+
+	if opts.pattern {
+		if err := drawHatching(rgba, features, scaleMap, opts, funcToScreen); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.halo && len(opts.uncertain) > 0 {
+		if err := drawUncertaintyHalos(rgba, features, scaleMap, opts, funcToScreen); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.showScale {
+		// Load the font
+		f, err := opts.loadFont()
+		if err != nil {
+			return nil, fmt.Errorf("failed to load font: %w", err)
+		}
+
+		// Context for scale value text drawing
+		c := newLabelRenderer(f, rgba, opts)
+		c.SetFontSize(14 * opts.multiplier)
 
-	if showScale {
 		// Scale values are drawn at the center of each prefecture
 		for _, feature := range features {
 			id := int(feature.Properties["id"].(float64))
@@ -179,110 +577,215 @@ func svgToPNG(svgData []byte, width, height int, footerText string, showScale bo
 				continue
 			}
 
+			// Use the center of the first polygon
 			var centerLon, centerLat float64
-			switch feature.Geometry.Type {
-			case "Polygon":
-				centerLon, centerLat = calculateCenter(feature.Geometry.Polygon[0])
-			case "MultiPolygon":
-				// Use the center of the first polygon
-				centerLon, centerLat = calculateCenter(feature.Geometry.MultiPolygon[0][0])
+			if rings := featureRings(feature); len(rings) > 0 && len(rings[0]) > 0 {
+				centerLon, centerLat = calculateCenter(rings[0])
 			}
 
 			// Converted to screen coordinates
 			x, y := funcToScreen(centerLon, centerLat)
 			pt := freetype.Pt(int(x)-5, int(y)+5)
-			_, err = c.DrawString(fmt.Sprintf("%d", scale), pt)
+			_, err = c.DrawString(scaleText(scale, opts.halves[id]), pt)
 			if err != nil {
 				return nil, fmt.Errorf("failed to draw scale value: %w", err)
 			}
 		}
 	}
 
-	pt := freetype.Pt(int(10*multiplier), height-int(14*multiplier))
-	_, err = c.DrawString(footerText, pt)
-	if err != nil {
-		return nil, fmt.Errorf("failed to draw footer text: %w", err)
+	if opts.labels {
+		if err := drawLabels(rgba, features, scaleMap, opts, funcToScreen); err != nil {
+			return nil, err
+		}
 	}
 
-	var buf bytes.Buffer
-	if err := png.Encode(&buf, rgba); err != nil {
-		return nil, fmt.Errorf("failed to encode png: %w", err)
+	if opts.showCrosshair {
+		if err := drawCrosshairLabels(rgba, opts, funcToScreen); err != nil {
+			return nil, err
+		}
 	}
-	return buf.Bytes(), nil
+
+	if opts.showEpicenter && opts.epicenterDepth >= 0 {
+		if err := drawEpicenterDepth(rgba, opts, funcToScreen); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.showScaleBar {
+		if err := drawScaleBarLabel(rgba, opts, funcToScreen); err != nil {
+			return nil, err
+		}
+	}
+	return rgba, nil
 }
 
-func mapHandler(w http.ResponseWriter, r *http.Request) {
-	scaleData := r.URL.Query().Get("scale")
-	if scaleData == "" {
-		http.Error(w, "scale parameter is required", http.StatusBadRequest)
-		return
+// Spacing of the footer lines as a share of the height of the text
+const footerLineSpacing = 1.25
+
+// Most lines of the footer, which stacks up over the map
+const maxFooterLines = 4
+
+// Function to get the height of the footer text in pixels
+func (opts renderOptions) footerHeight() float64 {
+	return opts.footerSize * opts.dpi / 72
+}
+
+// Function to get the lines of the footer, the license notice by default,
+// followed by the time of the render when requested
+func (opts renderOptions) footerLines() []string {
+	text := opts.footerText
+	if text == "" {
+		text = "Code available under the MIT License (GitHub: evacuate)."
 	}
+	lines := strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if !opts.timestamp.IsZero() {
+		lines = append(lines, "Generated "+opts.timestamp.UTC().Format("2006-01-02 15:04 UTC"))
+	}
+	return lines
+}
 
-	var intensities []IntensityQuery
-	if err := json.Unmarshal([]byte(scaleData), &intensities); err != nil {
-		http.Error(w, fmt.Sprintf("Invalid scale data format: %v", err), http.StatusBadRequest)
-		return
+// Function to get the height of the footer above the bottom edge, which the
+// overlays along the bottom keep clear of
+func (opts renderOptions) footerClearance() float64 {
+	return opts.footerHeight() * (1.75 + footerLineSpacing*float64(len(opts.footerLines())-1))
+}
+
+// Function to draw the footer and overlays of the final image and apply the
+// edge masks
+func decorateImage(rgba *image.RGBA, opts renderOptions) error {
+	footerLines := opts.footerLines()
+
+	// Load the font
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
 	}
 
-	scaleMap := make(map[int]int)
-	for _, intensity := range intensities {
-		// Check the intensity value
-		if intensity.Scale < 0 || intensity.Scale > 7 {
-			http.Error(w, fmt.Sprintf("Invalid scale value for ID %d: %d",
-				intensity.ID, intensity.Scale), http.StatusBadRequest)
-			return
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetFontSize(opts.footerSize)
+	c.SetColor(opts.footerColor)
+	c.SetDPI(opts.dpi)
+	measure := footerMeasure(f, opts)
+
+	// The last baseline is raised by the height of the text, which leaves
+	// room for the descenders at any size, and the lines stack up from it
+	for i, line := range footerLines {
+		above := float64(len(footerLines)-1-i) * footerLineSpacing * opts.footerHeight()
+		pt := freetype.Pt(int(10*opts.multiplier), rgba.Bounds().Dy()-int(opts.footerHeight()+above))
+		if _, err := c.DrawString(truncateText(line, opts.footerMaxWidth(), measure), pt); err != nil {
+			return fmt.Errorf("failed to draw footer text: %w", err)
 		}
-		scaleMap[intensity.ID] = intensity.Scale
 	}
+	// The other overlays are laid out in pixels
+	c.SetDPI(72)
+	c.SetColor(color.RGBA{0xfa, 0xfa, 0xfa, 0xff})
 
-	size := r.URL.Query().Get("size")
-	var multiplier float64 = 1.0
+	if opts.histogram != nil {
+		if err := drawHistogram(rgba, c, opts); err != nil {
+			return err
+		}
+	}
 
-	switch size {
-	case "1":
-		multiplier = 1.0 // 1280x720
-	case "2":
-		multiplier = 2.0 // 2560x1440
-	case "3":
-		multiplier = 4.0 // 5120x2880
+	if opts.legend != "" {
+		if err := drawLegend(rgba, c, opts); err != nil {
+			return err
+		}
+	}
+
+	if opts.showDataAge {
+		if err := drawFreshness(rgba, c, opts.dataAge, opts.dataAgeWarn, opts.dataAgeStale, opts.multiplier); err != nil {
+			return err
+		}
+	}
+
+	if opts.qrURL != "" {
+		margin := int(16 * opts.multiplier)
+		area := rgba.SubImage(image.Rect(0, 0, int(opts.mapWidth()), opts.height)).(*image.RGBA)
+		if err := drawQRCode(area, opts.qrURL, int(opts.qrSize), opts.qrPosition, margin); err != nil {
+			return err
+		}
+	}
+
+	if opts.fadeWidth > 0 {
+		applyFade(rgba, opts.fadeWidth, opts.fadeMode)
+	}
+
+	if opts.cornerRadius > 0 {
+		applyRoundedCorners(rgba, opts.cornerRadius)
+	}
+	return nil
+}
+
+// Function to encode a rendered image in the requested format, returning the
+// data along with its content type
+func encodeImage(ctx context.Context, rgba *image.RGBA, format string, opts renderOptions) ([]byte, string, error) {
+	switch format {
+	case "jpeg":
+		encode := func(img image.Image, quality int) ([]byte, error) {
+			return encodeJPEG(ctx, img, quality)
+		}
+		data, err := encodeLossy(flattenImage(rgba, opts.background), opts, encode)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode jpeg: %w", err)
+		}
+		return data, "image/jpeg", nil
+	case "webp":
+		encode := func(img image.Image, quality int) ([]byte, error) {
+			return encodeWebP(ctx, img, opts.lossless, quality)
+		}
+		data, err := encodeLossy(rgba, opts, encode)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to encode webp: %w", err)
+		}
+		return data, "image/webp", nil
 	default:
-		multiplier = 1.0
+		data, err := encodeImagePNG(ctx, rgba, opts)
+		return data, "image/png", err
 	}
+}
 
-	const (
-		BASE_WIDTH  = 1280.0
-		BASE_HEIGHT = 720.0
-	)
+// Function to encode a rendered image with a lossy encoder at the quality of
+// the request, or within the byte budget when there is one
+func encodeLossy(rgba *image.RGBA, opts renderOptions, encode func(img image.Image, quality int) ([]byte, error)) ([]byte, error) {
+	if opts.maxBytes > 0 {
+		return encodeLossyWithinBudget(rgba, opts, opts.maxBytes, encode)
+	}
+	return encode(rgba, opts.quality)
+}
 
-	CANVAS_WIDTH := BASE_WIDTH * multiplier
-	CANVAS_HEIGHT := BASE_HEIGHT * multiplier
+// Function to encode a rendered image as PNG
+func encodeImagePNG(ctx context.Context, rgba *image.RGBA, opts renderOptions) ([]byte, error) {
+	if opts.maxBytes > 0 {
+		return encodePNGWithinBudget(ctx, rgba, opts, opts.maxBytes)
+	}
 
-	data, err := os.ReadFile("japan.geojson")
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to read geojson: %v", err), http.StatusInternalServerError)
-		return
+	var img image.Image = rgba
+	if opts.indexed {
+		// Quantize to a palette since the map only uses a few flat colors
+		img = toPaletted(rgba, basePalette(opts))
 	}
 
-	fc, err := geojson.UnmarshalFeatureCollection(data)
+	data, err := encodePNGLevel(ctx, img, opts.compression)
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to unmarshal geojson: %v", err), http.StatusInternalServerError)
-		return
+		return nil, fmt.Errorf("failed to encode png: %w", err)
 	}
+	return data, nil
+}
 
-	// Calculate the valid area
-	minLon, minLat, maxLon, maxLat := calculateBounds(fc, scaleMap)
-
-	funcToScreen := func(lon, lat float64) (x, y float64) {
+// Function to create the projection from coordinates to screen pixels, fitting
+// the bounds into a canvas of the given size. The margin is the share of the
+// width and height left empty on each side.
+func newScreenProjection(minLon, minLat, maxLon, maxLat, width, height, margin float64) Projector {
+	return func(lon, lat float64) (x, y float64) {
 		// Calculate the effective drawing area
-		margin := 0.1
-		effectiveWidth := CANVAS_WIDTH * (1.0 - 2*margin)
-		effectiveHeight := CANVAS_HEIGHT * (1.0 - 2*margin)
+		effectiveWidth := width * (1.0 - 2*margin)
+		effectiveHeight := height * (1.0 - 2*margin)
 
 		// Calculate center coordinates only once
 		centerLat := (maxLat + minLat) / 2
 		centerLon := (maxLon + minLon) / 2
-		centerX := CANVAS_WIDTH / 2
-		centerY := CANVAS_HEIGHT / 2
+		centerX := width / 2
+		centerY := height / 2
 
 		// Calculate the correction factor for longitude distance by latitude
 		lonCorrection := math.Cos(centerLat * math.Pi / 180.0)
@@ -298,83 +801,1241 @@ func mapHandler(w http.ResponseWriter, r *http.Request) {
 		y = (centerLat-lat)*scale + centerY
 		return
 	}
+}
 
-	buf := new(bytes.Buffer)
-	canvas := svg.New(buf)
-	canvas.Start(int(CANVAS_WIDTH), int(CANVAS_HEIGHT))
-	canvas.Rect(0, 0, int(CANVAS_WIDTH), int(CANVAS_HEIGHT), "fill:#18181b")
-
-	for _, feature := range fc.Features {
-		id, ok := feature.Properties["id"].(float64)
-		if !ok {
-			http.Error(w, "Invalid ID format in GeoJSON", http.StatusInternalServerError)
-			return
+// Function to build an SVG path for a ring. Points closer than tolerance
+// pixels to the previously emitted point are skipped (0 keeps every point)
+func ringToPath(ring [][]float64, funcToScreen func(float64, float64) (float64, float64), tolerance float64) string {
+	var sb strings.Builder
+	var lastX, lastY float64
+	for i, coord := range ring {
+		x, y := funcToScreen(coord[0], coord[1])
+		if i == 0 {
+			fmt.Fprintf(&sb, "M%.1f %.1f", x, y)
+		} else if i == len(ring)-1 || math.Hypot(x-lastX, y-lastY) >= tolerance {
+			fmt.Fprintf(&sb, " L%.1f %.1f", x, y)
+		} else {
+			continue
 		}
+		lastX, lastY = x, y
+	}
+	sb.WriteString(" Z")
+	return sb.String()
+}
 
-		scaleValue := 0
-		if val, ok := scaleMap[int(id)]; ok {
-			scaleValue = val
+// Upper bound of the scale data sent in a POST body
+const maxScaleBodySize = 1 << 20
+
+// Time given to the requests in progress to finish when shutting down
+const shutdownTimeout = 30 * time.Second
+
+// Limits of the HTTP server. The header limit leaves room for the query
+// strings of GET renders, which can carry definitions and many intensities.
+const (
+	readHeaderTimeout = 10 * time.Second
+	readTimeout       = 30 * time.Second
+	idleTimeout       = 120 * time.Second
+	maxHeaderBytes    = 256 << 10
+)
+
+// Time allowed to render and write a response, configured with
+// WRITE_TIMEOUT since large renders can take a while to encode. Zero
+// disables the timeout.
+var writeTimeout = 2 * time.Minute
+
+func mapHandler(w http.ResponseWriter, r *http.Request) {
+	renderMap(w, r, false)
+}
+
+// Preview renders are always small and low detail so that UIs can update them
+// live while the parameters are being tweaked
+func previewHandler(w http.ResponseWriter, r *http.Request) {
+	renderMap(w, r, true)
+}
+
+func renderMap(w http.ResponseWriter, r *http.Request, preview bool) {
+	// Anything else, HEAD included, would go through a full render
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := expandPermalink(r); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid permalink token: %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, warning := range applyParamAliases(r) {
+		w.Header().Add("Warning", warning)
+	}
+
+	scaleData := r.URL.Query().Get("scale")
+	if r.Method == http.MethodPost {
+		// The intensities can be sent as the body instead, which avoids
+		// overly long URLs. The other options stay in the query.
+		mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil || mediaType != "application/json" {
+			http.Error(w, "Content-Type must be application/json", http.StatusUnsupportedMediaType)
+			return
 		}
-		fillColor := intensityToColor(scaleValue)
-
-		var paths []string
-		if feature.Geometry.Type == "Polygon" {
-			for _, ring := range feature.Geometry.Polygon {
-				var pathStr = "M"
-				for i, coord := range ring {
-					x, y := funcToScreen(coord[0], coord[1])
-					if i == 0 {
-						pathStr += fmt.Sprintf("%.1f %.1f", x, y)
-					} else {
-						pathStr += fmt.Sprintf(" L%.1f %.1f", x, y)
-					}
-				}
-				pathStr += " Z"
-				paths = append(paths, pathStr)
-			}
-		} else if feature.Geometry.Type == "MultiPolygon" {
-			for _, polygon := range feature.Geometry.MultiPolygon {
-				for _, ring := range polygon {
-					var pathStr = "M"
-					for i, coord := range ring {
-						x, y := funcToScreen(coord[0], coord[1])
-						if i == 0 {
-							pathStr += fmt.Sprintf("%.1f %.1f", x, y)
-						} else {
-							pathStr += fmt.Sprintf(" L%.1f %.1f", x, y)
-						}
-					}
-					pathStr += " Z"
-					paths = append(paths, pathStr)
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxScaleBodySize))
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to read request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		scaleData = string(body)
+		if strings.TrimSpace(scaleData) == "" {
+			http.Error(w, "Request body must contain the scale data", http.StatusBadRequest)
+			return
+		}
+	}
+	framesData := r.URL.Query().Get("frames")
+	if scaleData == "" && framesData == "" {
+		http.Error(w, "scale parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	var intensities []IntensityQuery
+	if scaleData != "" {
+		var err error
+		if intensities, err = parseIntensities(scaleData); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid scale data format: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		dedupe := r.URL.Query().Get("dedupe")
+		if dedupe == "" {
+			dedupe = defaultDedupeMode
+		}
+		if !isDedupeMode(dedupe) {
+			http.Error(w, fmt.Sprintf("Invalid dedupe value: %s", dedupe), http.StatusBadRequest)
+			return
+		}
+		if intensities, err = dedupeIntensities(intensities, dedupe); err != nil {
+			http.Error(w, fmt.Sprintf("Conflicting scale data: %v", err), http.StatusBadRequest)
+			return
+		}
+		logScaleCount(r, len(intensities))
+	}
+
+	// The same parameters always produce the same output, so a client
+	// holding the current version gets no body
+	etag := renderETag(r, intensities)
+	if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		setCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	// Popular payloads are served from memory without rendering them again
+	if renders.serve(w, etag) {
+		return
+	}
+	var storeRender func()
+	w, storeRender = renders.record(w, etag)
+	defer storeRender()
+
+	var frames []AnimationFrame
+	if framesData != "" {
+		if err := json.Unmarshal([]byte(framesData), &frames); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid frames data format: %v", err), http.StatusBadRequest)
+			return
+		}
+		if len(frames) > maxAnimationFrames {
+			http.Error(w, fmt.Sprintf("Too many frames: %d, at most %d", len(frames), maxAnimationFrames), http.StatusBadRequest)
+			return
+		}
+
+		if window := r.URL.Query().Get("window"); window != "" {
+			d, err := time.ParseDuration(window)
+			if err != nil || d <= 0 {
+				http.Error(w, fmt.Sprintf("Invalid window value: %s", window), http.StatusBadRequest)
+				return
+			}
+			frames = framesInWindow(frames, d)
+		}
+
+		if len(frames) == 0 {
+			http.Error(w, "No frames to render", http.StatusBadRequest)
+			return
+		}
+		if len(frames) > maxAnimationFrames {
+			http.Error(w, fmt.Sprintf("Too many frames: %d, at most %d", len(frames), maxAnimationFrames), http.StatusBadRequest)
+			return
+		}
+	}
+
+	scaleMap := make(map[int]int)
+	halves := make(map[int]int)
+	uncertain := make(map[int]bool)
+	history := make(map[int][]int)
+	customColors := make(map[int]string)
+	for _, intensity := range intensities {
+		// Check the intensity value
+		if intensity.Scale < 0 || intensity.Scale > 7 {
+			http.Error(w, fmt.Sprintf("Invalid scale value for ID %d: %d",
+				intensity.ID, intensity.Scale), http.StatusBadRequest)
+			return
+		}
+		if intensity.Color != "" {
+			if _, err := hexToRGBA(intensity.Color); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid color value for ID %d: %s", intensity.ID, intensity.Color), http.StatusBadRequest)
+				return
+			}
+			customColors[intensity.ID] = intensity.Color
+		}
+		scaleMap[intensity.ID] = intensity.Scale
+		delete(halves, intensity.ID)
+		if intensity.half != 0 {
+			halves[intensity.ID] = intensity.half
+		}
+		if intensity.Uncertain {
+			uncertain[intensity.ID] = true
+		}
+
+		if len(intensity.History) > maxHistoryLength {
+			http.Error(w, fmt.Sprintf("History of ID %d is too long (%d > %d)",
+				intensity.ID, len(intensity.History), maxHistoryLength), http.StatusBadRequest)
+			return
+		}
+		for _, scale := range intensity.History {
+			if scale < 0 || scale > 7 {
+				http.Error(w, fmt.Sprintf("Invalid history value for ID %d: %d",
+					intensity.ID, scale), http.StatusBadRequest)
+				return
+			}
+		}
+		if len(intensity.History) > 0 {
+			history[intensity.ID] = intensity.History
+		}
+	}
+
+	// Intensities used to compute the bounds, covering every map that is drawn
+	boundsMap := make(map[int]int, len(scaleMap))
+	for id, scale := range scaleMap {
+		boundsMap[id] = scale
+	}
+
+	frameMaps := make([]map[int]int, 0, len(frames))
+	for i, frame := range frames {
+		if frame.Delay != 0 && (frame.Delay < 20 || frame.Delay > 10000) {
+			http.Error(w, fmt.Sprintf("Invalid delay value of frame %d: %d", i, frame.Delay), http.StatusBadRequest)
+			return
+		}
+		frameMap := make(map[int]int)
+		for _, intensity := range frame.Scale {
+			if intensity.Scale < 0 || intensity.Scale > 7 {
+				http.Error(w, fmt.Sprintf("Invalid scale value for ID %d: %d",
+					intensity.ID, intensity.Scale), http.StatusBadRequest)
+				return
+			}
+			if intensity.half != 0 {
+				http.Error(w, fmt.Sprintf("Frames do not support the halves of 5 and 6 (ID %d)", intensity.ID), http.StatusBadRequest)
+				return
+			}
+			frameMap[intensity.ID] = intensity.Scale
+
+			// The bounds cover every frame so the map stays fixed
+			if intensity.Scale > boundsMap[intensity.ID] {
+				boundsMap[intensity.ID] = intensity.Scale
+			}
+		}
+		frameMaps = append(frameMaps, frameMap)
+	}
+
+	// Intensities shown on the "before" side of a split image
+	var beforeMap, beforeHalves map[int]int
+	if beforeData := r.URL.Query().Get("before"); beforeData != "" {
+		before, err := parseIntensities(beforeData)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid before data format: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		beforeMap = make(map[int]int)
+		beforeHalves = make(map[int]int)
+		for _, intensity := range before {
+			if intensity.Scale < 0 || intensity.Scale > 7 {
+				http.Error(w, fmt.Sprintf("Invalid scale value for ID %d: %d",
+					intensity.ID, intensity.Scale), http.StatusBadRequest)
+				return
+			}
+			beforeMap[intensity.ID] = intensity.Scale
+			delete(beforeHalves, intensity.ID)
+			if intensity.half != 0 {
+				beforeHalves[intensity.ID] = intensity.half
+			}
+			if intensity.Scale > boundsMap[intensity.ID] {
+				boundsMap[intensity.ID] = intensity.Scale
+			}
+		}
+	}
+
+	split := r.URL.Query().Get("split")
+	switch split {
+	case "":
+		if beforeMap != nil {
+			split = "vertical"
+		}
+	case "vertical", "horizontal":
+		if beforeMap == nil {
+			http.Error(w, "split parameter requires before", http.StatusBadRequest)
+			return
+		}
+	default:
+		http.Error(w, fmt.Sprintf("Invalid split value: %s", split), http.StatusBadRequest)
+		return
+	}
+
+	size := r.URL.Query().Get("size")
+	var multiplier float64 = 1.0
+
+	switch size {
+	case "1":
+		multiplier = 1.0 // 1280x720
+	case "2":
+		multiplier = 2.0 // 2560x1440
+	case "3":
+		multiplier = 4.0 // 5120x2880
+	default:
+		multiplier = 1.0
+	}
+
+	// Distance in pixels under which ring points are merged
+	tolerance := 0.0
+	if preview {
+		multiplier = 0.25 // 320x180
+		tolerance = 1.0
+	}
+
+	const (
+		BASE_WIDTH  = 1280.0
+		BASE_HEIGHT = 720.0
+	)
+
+	CANVAS_WIDTH := BASE_WIDTH * multiplier
+	CANVAS_HEIGHT := BASE_HEIGHT * multiplier
+
+	// Explicit dimensions, previews always keep their fixed size
+	if !preview && (r.URL.Query().Has("width") || r.URL.Query().Has("height")) {
+		for _, param := range []struct {
+			name  string
+			value *float64
+		}{{"width", &CANVAS_WIDTH}, {"height", &CANVAS_HEIGHT}} {
+			value := r.URL.Query().Get(param.name)
+			if value == "" {
+				continue
+			}
+			dimension, err := strconv.Atoi(value)
+			if err != nil || dimension <= 0 || dimension > 8192 {
+				http.Error(w, fmt.Sprintf("Invalid %s value: %s", param.name, value), http.StatusBadRequest)
+				return
+			}
+			*param.value = float64(dimension)
+		}
+
+		// Without an explicit size the text and strokes follow the canvas
+		if size == "" {
+			multiplier = min(CANVAS_WIDTH/BASE_WIDTH, CANVAS_HEIGHT/BASE_HEIGHT)
+		}
+	}
+
+	// High-DPI shorthand doubling the canvas along with the text and strokes,
+	// which are also supersampled unless the request sets the factor
+	scale2x := !preview && r.URL.Query().Get("scale2x") == "true"
+	if scale2x {
+		if CANVAS_WIDTH*2 > 8192 || CANVAS_HEIGHT*2 > 8192 {
+			http.Error(w, fmt.Sprintf("Canvas is too large for scale2x (%gx%g)", CANVAS_WIDTH, CANVAS_HEIGHT), http.StatusBadRequest)
+			return
+		}
+		CANVAS_WIDTH *= 2
+		CANVAS_HEIGHT *= 2
+		multiplier *= 2
+	}
+
+	base, ok := selectBaseMap(r.URL.Query().Get("map"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid map value: %s", r.URL.Query().Get("map")), http.StatusBadRequest)
+		return
+	}
+	if value := r.URL.Query().Get("geojsonUrl"); value != "" {
+		if err := validateGeoJSONURL(value); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid geojsonUrl value: %v", err), http.StatusBadRequest)
+			return
+		}
+		remote, err := fetchGeoJSON(r.Context(), value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to load geojsonUrl: %v", err), http.StatusBadGateway)
+			return
+		}
+		base = remote
+		geojsonCache.WithLabelValues("miss").Inc()
+	} else {
+		geojsonCache.WithLabelValues("hit").Inc()
+	}
+	fc := base.features
+
+	// An ID outside of the map is usually a bug in the client
+	for _, intensity := range intensities {
+		if !base.hasFeatureID(intensity.ID) {
+			http.Error(w, fmt.Sprintf("ID %d does not match any feature of the map", intensity.ID), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// In strict mode, requesting a prefecture that cannot be drawn is an error
+	for _, id := range base.emptyFeatureIDs {
+		if _, ok := boundsMap[id]; ok && r.URL.Query().Get("strict") == "true" {
+			http.Error(w, fmt.Sprintf("Prefecture ID %d has no geometry", id), http.StatusBadRequest)
+			return
+		}
+	}
+
+	colors := palettes["jma"]
+	if name := r.URL.Query().Get("palette"); name != "" {
+		var ok bool
+		if colors, ok = palettes[name]; !ok {
+			http.Error(w, fmt.Sprintf("Invalid palette value: %s", name), http.StatusBadRequest)
+			return
+		}
+	}
+
+	th, param, ok := parseTheme(r.URL.Query())
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid %s value: %s", param, r.URL.Query().Get(param)), http.StatusBadRequest)
+		return
+	}
+	colors = th.landPalette(colors)
+
+	format := r.URL.Query().Get("format")
+	if format == "datauri" {
+		// Render the selected encoding and return it inline as text
+		format = r.URL.Query().Get("encoding")
+		if format == "" {
+			format = "png"
+		}
+		if !isDataURIEncoding(format) {
+			http.Error(w, fmt.Sprintf("Invalid encoding value: %s", format), http.StatusBadRequest)
+			return
+		}
+		dataURI := &dataURIWriter{ResponseWriter: w}
+		defer dataURI.flush()
+		w = dataURI
+	}
+	if format == "webp" && !webpSupported {
+		http.Error(w, "webp not supported in this build", http.StatusBadRequest)
+		return
+	}
+
+	// The before side is only rendered into the raster images
+	if split != "" && format != "" && format != "png" && format != "jpeg" && format != "webp" {
+		http.Error(w, fmt.Sprintf("split cannot be combined with format=%s", format), http.StatusBadRequest)
+		return
+	}
+
+	switch format {
+	case "", "png", "jpeg", "webp", "gif", "mask", "svg", "symbol", "pdf", "json":
+	case "topojson":
+		// Return the geometry with the intensities instead of an image
+		topo := topologyWithScale(base.loadTopology(), base.name, scaleMap, renderOptions{palette: colors, halves: halves, colors: customColors})
+		setCacheHeaders(w, etag)
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(topo); err != nil {
+			log.Printf("failed to encode topojson: %v", err)
+		}
+		return
+	default:
+		http.Error(w, fmt.Sprintf("Invalid format value: %s", format), http.StatusBadRequest)
+		return
+	}
+
+	// The base layer keeps every prefecture even when the map is cropped
+	var baseLayer []*geojson.Feature
+	if r.URL.Query().Get("baseLayer") == "true" {
+		baseLayer = fc.Features
+	}
+
+	// So do the outlines of the context
+	var contextLayer []*geojson.Feature
+	switch value := r.URL.Query().Get("context"); value {
+	case "", "fill":
+	case "outline":
+		contextLayer = fc.Features
+	default:
+		http.Error(w, fmt.Sprintf("Invalid context value: %s", value), http.StatusBadRequest)
+		return
+	}
+
+	// Leave out the prefectures without intensity in every drawn map
+	if r.URL.Query().Get("cropToAffected") == "true" {
+		fc = affectedFeatures(fc, boundsMap)
+	}
+
+	// Only fit the bounds to prefectures at or above minScale, every
+	// prefecture is still drawn
+	if value := r.URL.Query().Get("minScale"); value != "" {
+		minScale, err := strconv.Atoi(value)
+		if err != nil || minScale < 1 || minScale > 7 {
+			http.Error(w, fmt.Sprintf("Invalid minScale value: %s", value), http.StatusBadRequest)
+			return
+		}
+
+		filtered := make(map[int]int, len(boundsMap))
+		for id, scale := range boundsMap {
+			if scale >= minScale {
+				filtered[id] = scale
+			}
+		}
+		// Fall back to all prefectures when none reach the threshold
+		if len(filtered) > 0 {
+			boundsMap = filtered
+		}
+	}
+
+	// Calculate the valid area, unless the viewport is pinned so that every
+	// render of a sequence shares it
+	minLon, minLat, maxLon, maxLat := calculateBounds(fc, boundsMap)
+	if value := r.URL.Query().Get("bounds"); value != "" {
+		parts := strings.Split(value, ",")
+		var bounds [4]float64
+		valid := len(parts) == 4
+		for i := 0; valid && i < 4; i++ {
+			var err error
+			bounds[i], err = strconv.ParseFloat(strings.TrimSpace(parts[i]), 64)
+			valid = err == nil && !math.IsNaN(bounds[i])
+		}
+		if !valid || bounds[0] < -180 || bounds[2] > 180 || bounds[1] < -90 || bounds[3] > 90 ||
+			bounds[0] >= bounds[2] || bounds[1] >= bounds[3] {
+			http.Error(w, fmt.Sprintf("Invalid bounds value: %s", value), http.StatusBadRequest)
+			return
+		}
+		minLon, minLat, maxLon, maxLat = bounds[0], bounds[1], bounds[2], bounds[3]
+	}
+
+	opts := renderOptions{
+		width:      int(CANVAS_WIDTH),
+		height:     int(CANVAS_HEIGHT),
+		multiplier: multiplier,
+		tolerance:  tolerance,
+		locator:    r.URL.Query().Get("locator") == "true",
+		footerText: r.URL.Query().Get("footer"),
+		showScale:  r.URL.Query().Get("scaleText") == "true",
+		pattern:    r.URL.Query().Get("pattern") == "true",
+		indexed:    r.URL.Query().Get("indexed") == "true",
+		fadeMode:   "edges",
+		smooth:     r.URL.Query().Get("smooth") != "false",
+		textSmooth: r.URL.Query().Get("textSmooth") != "false",
+		strokeMin:  0.25,
+		strokeMax:  4,
+		bathymetry: r.URL.Query().Get("bathymetry") == "true",
+		halo:       r.URL.Query().Get("halo") == "true",
+		uncertain:  uncertain,
+
+		strokeAffectedOnly: r.URL.Query().Get("strokeAffectedOnly") == "true",
+		labelHalo:          r.URL.Query().Get("labelHalo") == "true",
+		labelHaloColor:     color.RGBA{0x18, 0x18, 0x1b, 0xff},
+		footerSize:         14 * multiplier,
+		dpi:                72,
+		baseLayer:          baseLayer,
+		contextLayer:       contextLayer,
+		footerColor:        th.text,
+		history:            history,
+		sparklines:         r.URL.Query().Get("sparklines") == "true",
+		quality:            90,
+		palette:            colors,
+		lossless:           r.URL.Query().Get("lossless") == "true",
+		dataAgeWarn:        time.Minute,
+		dataAgeStale:       5 * time.Minute,
+		vector:             format == "svg" || format == "symbol" || format == "pdf",
+		labels:             r.URL.Query().Get("labels") == "true",
+		labelFontSize:      12 * multiplier,
+		epicenterDepth:     -1,
+		halves:             halves,
+		showScaleBar:       r.URL.Query().Get("scalebar") == "true",
+		center:             [2]float64{(minLon + maxLon) / 2, (minLat + maxLat) / 2},
+		graticule:          r.URL.Query().Get("graticule") == "true",
+		graticuleStep:      1,
+		supersample:        1,
+		background:         th.background,
+		margin:             0.1,
+		borderWidth:        -1,
+		strokeColor:        th.border,
+		fillOpacity:        th.fillOpacity,
+	}
+
+	opts.colors = customColors
+
+	// Only the SVG output keeps the tags of the prefectures
+	opts.interactive = (format == "svg" || format == "symbol") && r.URL.Query().Get("interactive") == "true"
+
+	// The split halves have no room for the inset map
+	if opts.locator && split != "" {
+		http.Error(w, "locator cannot be combined with split", http.StatusBadRequest)
+		return
+	}
+
+	// The sidebar is reserved on the right, the map is fitted to the rest
+	if r.URL.Query().Get("histogram") == "true" {
+		if split != "" {
+			http.Error(w, "histogram cannot be combined with split", http.StatusBadRequest)
+			return
+		}
+		opts.histogram = histogramCounts(scaleMap)
+	}
+
+	// Age of the data and the thresholds of the freshness indicator, in seconds
+	for _, param := range []struct {
+		name  string
+		value *time.Duration
+	}{{"dataAge", &opts.dataAge}, {"dataAgeWarn", &opts.dataAgeWarn}, {"dataAgeStale", &opts.dataAgeStale}} {
+		if value := r.URL.Query().Get(param.name); value != "" {
+			seconds, err := strconv.ParseFloat(value, 64)
+			if err != nil || seconds < 0 || seconds > 365*24*60*60 {
+				http.Error(w, fmt.Sprintf("Invalid %s value: %s", param.name, value), http.StatusBadRequest)
+				return
+			}
+			*param.value = time.Duration(seconds * float64(time.Second))
+		}
+	}
+	opts.showDataAge = r.URL.Query().Has("dataAge")
+	if opts.dataAgeWarn > opts.dataAgeStale {
+		http.Error(w, "dataAgeWarn must not be greater than dataAgeStale", http.StatusBadRequest)
+		return
+	}
+
+	if value := r.URL.Query().Get("graticuleStep"); value != "" {
+		step, err := strconv.ParseFloat(value, 64)
+		if err != nil || step < 0.1 || step > 30 {
+			http.Error(w, fmt.Sprintf("Invalid graticuleStep value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.graticuleStep = step
+	}
+
+	if name := r.URL.Query().Get("font"); name != "" {
+		font, ok := fontFiles[name]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid font value: %s", name), http.StatusBadRequest)
+			return
+		}
+		if _, err := loadFontFile(font.file); err != nil {
+			http.Error(w, fmt.Sprintf("Font %s is not available", name), http.StatusBadRequest)
+			return
+		}
+		opts.font = name
+	}
+
+	if value := r.URL.Query().Get("footerSize"); value != "" {
+		footerSize, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid footerSize value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.footerSize = math.Max(8, math.Min(48, footerSize)) * multiplier
+	}
+
+	if r.URL.Query().Get("timestamp") == "true" {
+		opts.timestamp = time.Now()
+	}
+	if lines := len(opts.footerLines()); lines > maxFooterLines {
+		http.Error(w, fmt.Sprintf("Footer has too many lines (%d > %d)", lines, maxFooterLines), http.StatusBadRequest)
+		return
+	}
+
+	if value := r.URL.Query().Get("dpi"); value != "" {
+		dpi, err := strconv.ParseFloat(value, 64)
+		if err != nil || dpi < 36 || dpi > 600 {
+			http.Error(w, fmt.Sprintf("Invalid dpi value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.dpi = dpi
+	}
+
+	// Long footers are shrunk to fit within the canvas
+	if f, err := opts.loadFont(); err == nil {
+		opts.footerSize = fitFooterSize(f, opts)
+	}
+
+	if value := r.URL.Query().Get("footerColor"); value != "" {
+		footerColor, err := hexToRGBA(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid footerColor value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.footerColor = footerColor
+	}
+
+	if value := r.URL.Query().Get("background"); value != "" {
+		background, err := parseBackground(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid background value: %s", value), http.StatusBadRequest)
+			return
+		}
+		if background.A == 0 && format == "jpeg" {
+			http.Error(w, "jpeg does not support a transparent background", http.StatusBadRequest)
+			return
+		}
+		opts.background = background
+	}
+
+	if value := r.URL.Query().Get("labelHaloColor"); value != "" {
+		haloColor, err := hexToRGBA(value)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid labelHaloColor value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.labelHaloColor = haloColor
+	}
+
+	// Client supplied definitions and the fills of the intensities using them
+	defIDs := map[string]string{}
+	if defs := r.URL.Query().Get("defs"); defs != "" {
+		var err error
+		opts.defs, defIDs, err = sanitizeDefs(defs)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid defs value: %v", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if value := r.URL.Query().Get("fills"); value != "" {
+		var fills map[string]string
+		if err := json.Unmarshal([]byte(value), &fills); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid fills data format: %v", err), http.StatusBadRequest)
+			return
+		}
+
+		opts.fills = make(map[int]string, len(fills))
+		for key, fill := range fills {
+			scale, err := strconv.Atoi(key)
+			if err != nil || scale < 0 || scale > 7 {
+				http.Error(w, fmt.Sprintf("Invalid fills scale: %s", key), http.StatusBadRequest)
+				return
+			}
+			if err := validateFill(fill, defIDs, format != "svg" && format != "symbol"); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid fills value: %v", err), http.StatusBadRequest)
+				return
+			}
+			opts.fills[scale] = fill
+		}
+	}
+
+	// Clamps of the border width in output pixels
+	for _, param := range []struct {
+		name  string
+		value *float64
+	}{{"strokeMin", &opts.strokeMin}, {"strokeMax", &opts.strokeMax}} {
+		if value := r.URL.Query().Get(param.name); value != "" {
+			width, err := strconv.ParseFloat(value, 64)
+			if err != nil || width < 0 || width > 50 {
+				http.Error(w, fmt.Sprintf("Invalid %s value: %s", param.name, value), http.StatusBadRequest)
+				return
+			}
+			*param.value = width
+		}
+	}
+	if opts.strokeMin > opts.strokeMax {
+		http.Error(w, "strokeMin must not be greater than strokeMax", http.StatusBadRequest)
+		return
+	}
+
+	// A fixed border width in output pixels replaces the clamped one, 0
+	// removes the borders
+	mode := r.URL.Query().Get("mode")
+	switch mode {
+	case "", "fill":
+	case "outline":
+		opts.outline = true
+		opts.outlineWidth = 2 * multiplier
+	default:
+		http.Error(w, fmt.Sprintf("Invalid mode value: %s", mode), http.StatusBadRequest)
+		return
+	}
+
+	if value := r.URL.Query().Get("strokeWidth"); value != "" {
+		width, err := strconv.ParseFloat(value, 64)
+		if err != nil || width < 0 || width > 50 || (opts.outline && width == 0) {
+			http.Error(w, fmt.Sprintf("Invalid strokeWidth value: %s", value), http.StatusBadRequest)
+			return
+		}
+		// With outlines the width is theirs, the other borders keep the default
+		if opts.outline {
+			opts.outlineWidth = width
+		} else {
+			opts.borderWidth = width
+		}
+	}
+
+	if qr := r.URL.Query().Get("qr"); qr != "" {
+		if err := validateQRURL(qr); err != nil {
+			http.Error(w, fmt.Sprintf("Invalid qr value: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.qrURL = qr
+		opts.qrSize = 96 * multiplier
+		opts.qrPosition = "bottomright"
+
+		if size := r.URL.Query().Get("qrSize"); size != "" {
+			qrSize, err := strconv.ParseFloat(size, 64)
+			if err != nil || qrSize < 32 || qrSize > 512 {
+				http.Error(w, fmt.Sprintf("Invalid qrSize value: %s", size), http.StatusBadRequest)
+				return
+			}
+			opts.qrSize = qrSize * multiplier
+		}
+
+		if position := r.URL.Query().Get("qrPosition"); position != "" {
+			if !isCornerPosition(position) {
+				http.Error(w, fmt.Sprintf("Invalid qrPosition value: %s", position), http.StatusBadRequest)
+				return
+			}
+			opts.qrPosition = position
+		}
+		if opts.locator && opts.qrPosition == "topright" {
+			http.Error(w, "locator and qrPosition must not share a corner", http.StatusBadRequest)
+			return
+		}
+	}
+
+	if size := r.URL.Query().Get("labelFontSize"); size != "" {
+		fontSize, err := strconv.ParseFloat(size, 64)
+		if err != nil || fontSize < 6 || fontSize > 72 {
+			http.Error(w, fmt.Sprintf("Invalid labelFontSize value: %s", size), http.StatusBadRequest)
+			return
+		}
+		opts.labelFontSize = fontSize * multiplier
+	}
+
+	if legend := r.URL.Query().Get("legend"); legend != "" && legend != "none" {
+		if !isCornerPosition(legend) {
+			http.Error(w, fmt.Sprintf("Invalid legend value: %s", legend), http.StatusBadRequest)
+			return
+		}
+		if opts.qrURL != "" && opts.qrPosition == legend {
+			http.Error(w, "legend and qrPosition must not share a corner", http.StatusBadRequest)
+			return
+		}
+		opts.legend = legend
+	}
+
+	// Order of the legend entries, the highest intensity on top by default
+	// as in the JMA presentation
+	opts.legendOrder = "desc"
+	if order := r.URL.Query().Get("legendOrder"); order != "" {
+		if order != "asc" && order != "desc" {
+			http.Error(w, fmt.Sprintf("Invalid legendOrder value: %s", order), http.StatusBadRequest)
+			return
+		}
+		opts.legendOrder = order
+	}
+
+	if fade := r.URL.Query().Get("fade"); fade != "" {
+		fadeWidth, err := strconv.ParseFloat(fade, 64)
+		if err != nil || fadeWidth < 0 {
+			http.Error(w, fmt.Sprintf("Invalid fade value: %s", fade), http.StatusBadRequest)
+			return
+		}
+		opts.fadeWidth = fadeWidth * multiplier
+	}
+
+	if radius := r.URL.Query().Get("cornerRadius"); radius != "" {
+		cornerRadius, err := strconv.ParseFloat(radius, 64)
+		if err != nil || cornerRadius < 0 {
+			http.Error(w, fmt.Sprintf("Invalid cornerRadius value: %s", radius), http.StatusBadRequest)
+			return
+		}
+		opts.cornerRadius = cornerRadius * multiplier
+	}
+
+	if fadeMode := r.URL.Query().Get("fadeMode"); fadeMode != "" {
+		if !isFadeMode(fadeMode) {
+			http.Error(w, fmt.Sprintf("Invalid fadeMode value: %s", fadeMode), http.StatusBadRequest)
+			return
+		}
+		opts.fadeMode = fadeMode
+	}
+
+	// Projection, the standard parallels and central meridian default to the
+	// bounds so that only the name is needed in most cases. The default
+	// projection only corrects the longitudes at the center latitude, which
+	// squashes the north of tall maps, so those get a conformal one.
+	name := r.URL.Query().Get("projection")
+	if name == "" && maxLat-minLat > maxEquirectangularSpan {
+		name = "lcc"
+	}
+	if name != "" {
+		if !isProjection(name) {
+			http.Error(w, fmt.Sprintf("Invalid projection value: %s", name), http.StatusBadRequest)
+			return
+		}
+
+		latSpan := maxLat - minLat
+		lat1, lat2 := minLat+latSpan/6, maxLat-latSpan/6
+		if value := r.URL.Query().Get("parallels"); value != "" {
+			parts := strings.Split(value, ",")
+			var err1, err2 error
+			if len(parts) == 2 {
+				lat1, err1 = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+				lat2, err2 = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+			}
+			if len(parts) != 2 || err1 != nil || err2 != nil || math.Abs(lat1) > 89 || math.Abs(lat2) > 89 {
+				http.Error(w, fmt.Sprintf("Invalid parallels value: %s", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		lon0 := (minLon + maxLon) / 2
+		if value := r.URL.Query().Get("centralMeridian"); value != "" {
+			meridian, err := strconv.ParseFloat(value, 64)
+			if err != nil || meridian < -180 || meridian > 180 {
+				http.Error(w, fmt.Sprintf("Invalid centralMeridian value: %s", value), http.StatusBadRequest)
+				return
+			}
+			lon0 = meridian
+		}
+
+		projection, err := newPlanarProjection(name, lat1, lat2, lon0)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid projection parameters: %v", err), http.StatusBadRequest)
+			return
+		}
+		opts.projection = projection
+	}
+
+	if value := r.URL.Query().Get("generalize"); value != "" {
+		strength, err := strconv.ParseFloat(value, 64)
+		if err != nil || strength < 0 || strength > 20 {
+			http.Error(w, fmt.Sprintf("Invalid generalize value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.generalize = strength * multiplier
+	}
+
+	if value := r.URL.Query().Get("crosshair"); value != "" {
+		parts := strings.Split(value, ",")
+		var errLon, errLat error
+		if len(parts) == 2 {
+			opts.crosshair[0], errLon = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			opts.crosshair[1], errLat = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if len(parts) != 2 || errLon != nil || errLat != nil || math.Abs(opts.crosshair[0]) > 180 || math.Abs(opts.crosshair[1]) > 90 {
+			http.Error(w, fmt.Sprintf("Invalid crosshair value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.showCrosshair = true
+
+		if rings := r.URL.Query().Get("crosshairRings"); rings != "" {
+			parts := strings.Split(rings, ",")
+			if len(parts) > maxCrosshairRings {
+				http.Error(w, fmt.Sprintf("Too many crosshairRings (%d > %d)", len(parts), maxCrosshairRings), http.StatusBadRequest)
+				return
+			}
+			for _, part := range parts {
+				radius, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+				if err != nil || radius <= 0 || radius > 2000 {
+					http.Error(w, fmt.Sprintf("Invalid crosshairRings value: %s", part), http.StatusBadRequest)
+					return
 				}
+				opts.crosshairRings = append(opts.crosshairRings, radius)
 			}
 		}
+	}
 
-		finalPath := ""
-		for _, p := range paths {
-			finalPath += p + " "
+	if value := r.URL.Query().Get("epicenter"); value != "" {
+		parts := strings.Split(value, ",")
+		var errLon, errLat error
+		if len(parts) == 2 {
+			opts.epicenter[0], errLon = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			opts.epicenter[1], errLat = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if len(parts) != 2 || errLon != nil || errLat != nil || math.Abs(opts.epicenter[0]) > 180 || math.Abs(opts.epicenter[1]) > 90 {
+			http.Error(w, fmt.Sprintf("Invalid epicenter value: %s", value), http.StatusBadRequest)
+			return
 		}
+		opts.showEpicenter = true
 
-		strokeWidth := 0.4 * multiplier
-		style := fmt.Sprintf("fill:%s;stroke:#a1a1aa;stroke-width:%.1f;fill-opacity:0.8",
-			fillColor, strokeWidth)
-		canvas.Path(finalPath, style)
+		if depth := r.URL.Query().Get("epicenterDepth"); depth != "" {
+			epicenterDepth, err := strconv.ParseFloat(depth, 64)
+			if err != nil || epicenterDepth < 0 || epicenterDepth > maxEpicenterDepth {
+				http.Error(w, fmt.Sprintf("Invalid epicenterDepth value: %s", depth), http.StatusBadRequest)
+				return
+			}
+			opts.epicenterDepth = epicenterDepth
+		}
 	}
 
-	footerText := r.URL.Query().Get("footer")
-	showScale := r.URL.Query().Get("scale_text") == "true"
+	if value := r.URL.Query().Get("center"); value != "" {
+		parts := strings.Split(value, ",")
+		var center [2]float64
+		var errLon, errLat error
+		if len(parts) == 2 {
+			center[0], errLon = strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
+			center[1], errLat = strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+		}
+		if len(parts) != 2 || errLon != nil || errLat != nil || math.Abs(center[0]) > 180 || math.Abs(center[1]) > 90 {
+			http.Error(w, fmt.Sprintf("Invalid center value: %s", value), http.StatusBadRequest)
+			return
+		}
+		if center[0] < 0 && maxLon > 180 {
+			// Bounds across the antimeridian continue past 180
+			center[0] += 360
+		}
+		opts.center = center
+		opts.recenter = true
+	}
 
-	canvas.End()
+	if value := r.URL.Query().Get("quality"); value != "" {
+		quality, err := strconv.Atoi(value)
+		if err != nil || quality < 1 || quality > 100 {
+			http.Error(w, fmt.Sprintf("Invalid quality value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.quality = quality
+	}
 
-	// Convert SVG to PNG
-	pngData, err := svgToPNG(buf.Bytes(), int(CANVAS_WIDTH), int(CANVAS_HEIGHT), footerText, showScale, float64(multiplier), fc.Features, scaleMap, funcToScreen)
+	if value := r.URL.Query().Get("margin"); value != "" {
+		margin, err := strconv.ParseFloat(value, 64)
+		if err != nil || margin < 0 || margin > 0.4 {
+			http.Error(w, fmt.Sprintf("Invalid margin value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.margin = margin
+	}
+
+	if value := r.URL.Query().Get("supersample"); value != "" {
+		supersample, err := strconv.Atoi(value)
+		if err != nil || supersample < 1 || supersample > 4 || opts.width*supersample > 8192 || opts.height*supersample > 8192 {
+			http.Error(w, fmt.Sprintf("Invalid supersample value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.supersample = supersample
+	} else if scale2x && opts.width*2 <= 8192 && opts.height*2 <= 8192 {
+		opts.supersample = 2
+	}
+
+	if value := r.URL.Query().Get("rotate"); value != "" {
+		rotate, err := strconv.Atoi(value)
+		if err != nil || (rotate != 0 && rotate != 90 && rotate != 180 && rotate != 270) {
+			http.Error(w, fmt.Sprintf("Invalid rotate value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.rotate = rotate
+	}
+
+	// Compression level of the png output, maxBytes picks its own levels
+	if value := r.URL.Query().Get("compression"); value != "" {
+		level, ok := pngCompressionLevels[value]
+		if !ok {
+			http.Error(w, fmt.Sprintf("Invalid compression value: %s", value), http.StatusBadRequest)
+			return
+		}
+		opts.compression = level
+	}
+
+	if value := r.URL.Query().Get("maxBytes"); value != "" {
+		maxBytes, err := strconv.Atoi(value)
+		if err != nil || maxBytes < minByteBudget {
+			http.Error(w, fmt.Sprintf("Invalid maxBytes value: %s", value), http.StatusBadRequest)
+			return
+		}
+		if (format != "" && format != "png" && format != "jpeg" && format != "webp") || len(frameMaps) > 0 {
+			http.Error(w, "maxBytes parameter requires png, jpeg or webp output", http.StatusBadRequest)
+			return
+		}
+		opts.maxBytes = maxBytes
+	}
+
+	funcToScreen := opts.screenProjection(minLon, minLat, maxLon, maxLat, opts.mapWidth(), CANVAS_HEIGHT)
+
+	if opts.showEpicenter {
+		// An epicenter off the rendered area is left out rather than
+		// drawn at the edge
+		x, y := funcToScreen(opts.epicenter[0], opts.epicenter[1])
+		if x < 0 || y < 0 || x > opts.mapWidth() || y > CANVAS_HEIGHT {
+			opts.showEpicenter = false
+		}
+	}
+
+	if format == "json" {
+		// Describe the render instead of drawing it
+		w.Header().Set("Content-Type", "application/json")
+		setCacheHeaders(w, etag)
+		if err := json.NewEncoder(w).Encode(buildManifest(fc, scaleMap, opts, minLon, minLat, maxLon, maxLat)); err != nil {
+			log.Printf("failed to encode manifest: %v", err)
+		}
+		return
+	}
+
+	if format == "mask" {
+		mask, err := renderLandMask(fc, opts, funcToScreen)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render mask: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		pngData, err := encodePNGLevel(r.Context(), maskToGray(mask), opts.compression)
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			http.Error(w, "Timed out while encoding png", http.StatusServiceUnavailable)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to encode png: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		setCacheHeaders(w, etag)
+		w.Write(pngData)
+		return
+	}
+
+	if len(frames) > 0 && format != "gif" {
+		http.Error(w, "frames parameter requires format=gif", http.StatusBadRequest)
+		return
+	}
+
+	if format == "svg" || format == "symbol" {
+		// Only the vector layers are included, the raster overlays (text,
+		// halos, QR code, masks) need png output
+		var svgData []byte
+		var err error
+		if format == "symbol" {
+			symbolID := r.URL.Query().Get("symbolId")
+			if symbolID == "" {
+				symbolID = "map"
+			}
+			if !defsRefPattern.MatchString("#" + symbolID) {
+				http.Error(w, fmt.Sprintf("Invalid symbolId value: %s", symbolID), http.StatusBadRequest)
+				return
+			}
+			svgData, err = buildSymbol(fc, scaleMap, opts, funcToScreen, symbolID)
+		} else {
+			svgData, err = buildSVG(fc, scaleMap, opts, funcToScreen)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to build svg: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/svg+xml")
+		setCacheHeaders(w, etag)
+		w.Write(svgData)
+		return
+	}
+
+	if format == "pdf" {
+		pdfData, err := renderPDF(fc, scaleMap, opts, funcToScreen)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render pdf: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/pdf")
+		setCacheHeaders(w, etag)
+		w.Write(pdfData)
+		return
+	}
+
+	if format == "gif" {
+		reveal := r.URL.Query().Get("reveal")
+		if reveal == "" {
+			reveal = "desc"
+		}
+		if reveal != "asc" && reveal != "desc" {
+			http.Error(w, fmt.Sprintf("Invalid reveal value: %s", reveal), http.StatusBadRequest)
+			return
+		}
+
+		delay := 800
+		if value := r.URL.Query().Get("delay"); value != "" {
+			var err error
+			delay, err = strconv.Atoi(value)
+			if err != nil || delay < 20 || delay > 10000 {
+				http.Error(w, fmt.Sprintf("Invalid delay value: %s", value), http.StatusBadRequest)
+				return
+			}
+		}
+
+		var gifData []byte
+		var err error
+		if len(frameMaps) > 0 {
+			if len(frameMaps)*opts.width*opts.height > maxAnimationPixels {
+				http.Error(w, fmt.Sprintf("Too many frames for a %dx%d canvas: %d", opts.width, opts.height, len(frameMaps)), http.StatusBadRequest)
+				return
+			}
+
+			// The halves given with scale do not apply to the frames
+			frameOpts := opts
+			frameOpts.halves = nil
+
+			// Frames without their own delay use the parameter, the last one
+			// is then held a little longer before looping
+			delays := make([]int, len(frames))
+			for i, frame := range frames {
+				delays[i] = frame.Delay
+				if delays[i] == 0 {
+					delays[i] = delay
+					if i == len(frames)-1 {
+						delays[i] *= 3
+					}
+				}
+			}
+			gifData, err = renderAnimation(r.Context(), fc, frameMaps, frameOpts, funcToScreen, delays)
+		} else {
+			gifData, err = renderRevealGIF(r.Context(), fc, scaleMap, opts, funcToScreen, reveal, delay)
+		}
+		if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+			http.Error(w, "Timed out while encoding gif", http.StatusServiceUnavailable)
+			return
+		}
+		if errors.Is(err, errTooManyFrames) {
+			http.Error(w, fmt.Sprintf("Too many frames for a %dx%d canvas", opts.width, opts.height), http.StatusBadRequest)
+			return
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render gif: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "image/gif")
+		setCacheHeaders(w, etag)
+		w.Write(gifData)
+		return
+	}
+
+	// Render the map, next to the before state when splitting
+	var rgba *image.RGBA
+	var err error
+	if split != "" {
+		rgba, err = renderSplitImage(fc, beforeMap, beforeHalves, scaleMap, opts, split, minLon, minLat, maxLon, maxLat)
+	} else {
+		rgba, err = renderImage(fc, scaleMap, opts, funcToScreen)
+	}
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to convert svg to png: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Failed to render map: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	w.Header().Set("Content-Type", "image/png")
-	w.Write(pngData)
+	imageData, contentType, err := encodeImage(r.Context(), rgba, format, opts)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		http.Error(w, "Timed out while encoding image", http.StatusServiceUnavailable)
+		return
+	}
+	if errors.Is(err, errOverBudget) {
+		http.Error(w, fmt.Sprintf("Output does not fit into %d bytes", opts.maxBytes), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode image: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if opts.maxBytes > 0 {
+		w.Header().Set("X-Output-Size", strconv.Itoa(len(imageData)))
+	}
+	w.Header().Set("Content-Type", contentType)
+	setCacheHeaders(w, etag)
+	w.Write(imageData)
 }
 
 func min(a, b float64) float64 {
@@ -391,11 +2052,115 @@ func max(a, b float64) float64 {
 	return b
 }
 
+// Function to get the address to listen on: the -addr flag, then ADDR, then
+// PORT as set by platforms such as Cloud Run, then :8080
+func listenAddr(flagAddr string) string {
+	if flagAddr != "" {
+		return flagAddr
+	}
+	if addr := os.Getenv("ADDR"); addr != "" {
+		return addr
+	}
+	if port := os.Getenv("PORT"); port != "" {
+		return ":" + port
+	}
+	return ":8080"
+}
+
 func main() {
-	http.HandleFunc("/map", mapHandler)
+	addr := flag.String("addr", "", "address to listen on (default $ADDR, :$PORT or :8080)")
+	flag.Parse()
+
+	if timeout := os.Getenv("ENCODE_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			log.Fatalf("Invalid ENCODE_TIMEOUT: %v", err)
+		}
+		encodeTimeout = d
+	}
+	if size := os.Getenv("RENDER_CACHE_BYTES"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil || n < 0 {
+			log.Fatalf("Invalid RENDER_CACHE_BYTES: %s", size)
+		}
+		renders = newRenderCache(n)
+	}
+	if timeout := os.Getenv("WRITE_TIMEOUT"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil || d < 0 {
+			log.Fatalf("Invalid WRITE_TIMEOUT: %s", timeout)
+		}
+		writeTimeout = d
+	}
+
+	geojsonAllowedHosts = parseAllowedHosts(os.Getenv("GEOJSON_ALLOWED_HOSTS"))
+
+	if limit := os.Getenv("RATE_LIMIT"); limit != "" {
+		perSecond, err := strconv.ParseFloat(limit, 64)
+		if err != nil || perSecond <= 0 {
+			log.Fatalf("Invalid RATE_LIMIT: %s", limit)
+		}
+		burst := defaultRateBurst
+		if value := os.Getenv("RATE_BURST"); value != "" {
+			if burst, err = strconv.Atoi(value); err != nil || burst < 1 {
+				log.Fatalf("Invalid RATE_BURST: %s", value)
+			}
+		}
+		renderLimiter = newClientLimiter(perSecond, burst)
+	}
+	if proxies := os.Getenv("TRUSTED_PROXIES"); proxies != "" {
+		var err error
+		if trustedProxies, err = parseTrustedProxies(proxies); err != nil {
+			log.Fatalf("Invalid TRUSTED_PROXIES: %v", err)
+		}
+	}
+
+	if err := loadBaseMaps(embeddedMaps, mapsDir); err != nil {
+		log.Fatalf("Failed to load the maps: %v", err)
+	}
+
+	http.HandleFunc("/map", withAccessLog(withRateLimit(mapHandler)))
+	http.HandleFunc("/map/preview", withAccessLog(withRateLimit(previewHandler)))
+	http.HandleFunc("/tile/{z}/{x}/{y}", withAccessLog(withRateLimit(tileHandler)))
+	http.HandleFunc("/{$}", playgroundHandler)
+	http.HandleFunc("/preview", playgroundHandler)
+	http.HandleFunc("/permalink", permalinkHandler)
+	http.HandleFunc("/lookup", lookupHandler)
+	http.HandleFunc("/healthz", healthHandler)
+	http.Handle("/metrics", promhttp.Handler())
 
-	log.Println("Starting server on :8080")
-	if err := http.ListenAndServe(":8080", nil); err != nil {
+	// Stop accepting connections on SIGINT or SIGTERM and give the renders
+	// in progress time to finish before exiting
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	server := &http.Server{
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+		MaxHeaderBytes:    maxHeaderBytes,
+	}
+	drained := make(chan struct{})
+	go func() {
+		defer close(drained)
+		<-ctx.Done()
+		log.Println("Shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			log.Printf("Failed to shut down gracefully: %v", err)
+		}
+	}()
+
+	listener, err := net.Listen("tcp", listenAddr(*addr))
+	if err != nil {
+		log.Fatal(err)
+	}
+	log.Printf("Starting server on %s", listener.Addr())
+	if err := server.Serve(listener); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
+	// Serve returns as soon as the shutdown starts
+	<-drained
 }
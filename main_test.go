@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"slices"
+	"sync"
+	"testing"
+	"testing/fstest"
+
+	"github.com/golang/freetype/truetype"
+)
+
+func TestMain(m *testing.M) {
+	if err := loadBaseMaps(embeddedMaps, mapsDir); err != nil {
+		log.Fatalf("Failed to load the maps: %v", err)
+	}
+	os.Exit(m.Run())
+}
+
+func TestIntensityToColor(t *testing.T) {
+	tests := []struct {
+		scale int
+		want  string
+	}{
+		{-1, "#27272a"},
+		{0, "#27272a"},
+		{1, "#bae6fd"},
+		{2, "#4ade80"},
+		{3, "#facc15"},
+		{4, "#f97316"},
+		{5, "#dc2626"},
+		{6, "#86198f"},
+		{7, "#500724"},
+		// Above the scale keeps the color of the strongest intensity
+		{8, "#500724"},
+		{100, "#500724"},
+	}
+	for _, tt := range tests {
+		if got := intensityToColor(tt.scale); got != tt.want {
+			t.Errorf("intensityToColor(%d) = %s, want %s", tt.scale, got, tt.want)
+		}
+	}
+}
+
+// GeoJSON with one drawable prefecture and one without geometry
+const nullGeometryGeoJSON = `{"type": "FeatureCollection", "features": [
+	{"type": "Feature", "properties": {"id": 1}, "geometry": {"type": "Polygon", "coordinates": [[[140, 40], [141, 40], [141, 41], [140, 40]]]}},
+	{"type": "Feature", "properties": {"id": 2}, "geometry": null}
+]}`
+
+func TestNullGeometry(t *testing.T) {
+	fsys := fstest.MapFS{"null.geojson": {Data: []byte(nullGeometryGeoJSON)}}
+	fc, emptyIDs, err := loadFeatures(fsys, "null.geojson")
+	if err != nil {
+		t.Fatalf("loadFeatures: %v", err)
+	}
+	if len(fc.Features) != 1 || fc.Features[0].Properties["id"] != 1.0 {
+		t.Errorf("loadFeatures kept %d features, want only the one with geometry", len(fc.Features))
+	}
+	if !slices.Equal(emptyIDs, []int{2}) {
+		t.Errorf("loadFeatures reported empty IDs %v, want [2]", emptyIDs)
+	}
+
+	// The same map served remotely goes through the render
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(nullGeometryGeoJSON))
+	}))
+	defer server.Close()
+	u, _ := url.Parse(server.URL)
+	defer func(hosts map[string]bool) { geojsonAllowedHosts = hosts }(geojsonAllowedHosts)
+	geojsonAllowedHosts = map[string]bool{u.Hostname(): true}
+
+	tests := []struct {
+		strict string
+		want   int
+	}{
+		{"false", http.StatusOK},
+		{"true", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		query := url.Values{
+			"geojsonUrl": {server.URL},
+			"scale":      {`[{"id": 1, "scale": 3}, {"id": 2, "scale": 5}]`},
+			"strict":     {tt.strict},
+		}
+		rec := httptest.NewRecorder()
+		mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+query.Encode(), nil))
+		if rec.Code != tt.want {
+			t.Errorf("strict=%s: status %d, want %d: %s", tt.strict, rec.Code, tt.want, rec.Body.String())
+		}
+	}
+}
+
+// Concurrent renders on a cold font cache must neither race (run with
+// go test -race) nor differ in their output
+func TestConcurrentRenders(t *testing.T) {
+	defer func(cache *renderCache) { renders = cache }(renders)
+	renders = newRenderCache(0)
+	fontCacheMu.Lock()
+	fontCache = map[string]*truetype.Font{}
+	fontCacheMu.Unlock()
+
+	const requests = 50
+	query := url.Values{
+		"scale":  {`[{"id": 13, "scale": 5}, {"id": 14, "scale": 3}, {"id": 11, "scale": 2}]`},
+		"width":  {"320"},
+		"height": {"180"},
+		"map":    {"kanto"},
+		"labels": {"true"},
+	}
+	bodies := make([][]byte, requests)
+	var wg sync.WaitGroup
+	for i := range requests {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+query.Encode(), nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("request %d: status %d: %s", i, rec.Code, rec.Body.String())
+				return
+			}
+			bodies[i] = rec.Body.Bytes()
+		}()
+	}
+	wg.Wait()
+
+	for i, body := range bodies[1:] {
+		if body != nil && bodies[0] != nil && !bytes.Equal(body, bodies[0]) {
+			t.Errorf("request %d rendered differently from request 0", i+1)
+		}
+	}
+}
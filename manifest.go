@@ -0,0 +1,49 @@
+package main
+
+import (
+	"sort"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Affected prefecture listed in a manifest
+type ManifestPrefecture struct {
+	ID    int    `json:"id"`
+	Name  string `json:"name"`
+	Scale int    `json:"scale"`
+	Label string `json:"label"` // Scale as written on the map, e.g. "5-"
+	Color string `json:"color"`
+}
+
+// Summary of a render, returned by format=json instead of the image
+type Manifest struct {
+	Prefectures []ManifestPrefecture `json:"prefectures"`
+	Bounds      [4]float64           `json:"bounds"` // minLon, minLat, maxLon, maxLat like a GeoJSON bbox
+}
+
+// Function to describe the affected prefectures of the map as they would be
+// drawn, ordered by ID
+func buildManifest(fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, minLon, minLat, maxLon, maxLat float64) Manifest {
+	manifest := Manifest{
+		Prefectures: []ManifestPrefecture{},
+		Bounds:      [4]float64{minLon, minLat, maxLon, maxLat},
+	}
+	for _, feature := range fc.Features {
+		id := int(feature.Properties["id"].(float64))
+		scale := scaleMap[id]
+		if scale == 0 {
+			continue
+		}
+		manifest.Prefectures = append(manifest.Prefectures, ManifestPrefecture{
+			ID:    id,
+			Name:  featureName(feature),
+			Scale: scale,
+			Label: scaleText(scale, opts.halves[id]),
+			Color: opts.fillColor(id, scale),
+		})
+	}
+	sort.Slice(manifest.Prefectures, func(i, j int) bool {
+		return manifest.Prefectures[i].ID < manifest.Prefectures[j].ID
+	})
+	return manifest
+}
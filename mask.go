@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	geojson "github.com/paulmach/go.geojson"
+	"github.com/srwiley/oksvg"
+	"github.com/srwiley/rasterx"
+)
+
+// Function to check whether the fade mode is supported
+func isFadeMode(mode string) bool {
+	switch mode {
+	case "edges", "horizontal", "vertical", "radial":
+		return true
+	}
+	return false
+}
+
+// Function to fade the image to transparent towards its edges so that it
+// blends into the surrounding page when embedded
+func applyFade(img *image.RGBA, fadeWidth float64, mode string) {
+	bounds := img.Bounds()
+	width := float64(bounds.Dx())
+	height := float64(bounds.Dy())
+
+	// Linear ramp from 0 at the edge to 1 at fadeWidth, eased for a softer look
+	ramp := func(d float64) float64 {
+		t := math.Max(0, math.Min(1, d/fadeWidth))
+		return t * t * (3 - 2*t)
+	}
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Distance of the pixel center to the left/top and right/bottom edges
+			px := float64(x-bounds.Min.X) + 0.5
+			py := float64(y-bounds.Min.Y) + 0.5
+			dx := min(px, width-px)
+			dy := min(py, height-py)
+
+			var alpha float64
+			switch mode {
+			case "horizontal":
+				alpha = ramp(dx)
+			case "vertical":
+				alpha = ramp(dy)
+			case "radial":
+				// Distance to the ellipse inscribed in the image
+				nx := (px - width/2) / (width / 2)
+				ny := (py - height/2) / (height / 2)
+				alpha = ramp((1 - math.Hypot(nx, ny)) * min(width, height) / 2)
+			default:
+				alpha = ramp(dx) * ramp(dy)
+			}
+
+			if alpha < 1 {
+				scaleAlpha(img, x, y, alpha)
+			}
+		}
+	}
+}
+
+// Function to multiply the alpha of a pixel (the RGBA buffer is premultiplied,
+// so the color channels are scaled as well)
+func scaleAlpha(img *image.RGBA, x, y int, alpha float64) {
+	i := img.PixOffset(x, y)
+	for j := 0; j < 4; j++ {
+		img.Pix[i+j] = uint8(float64(img.Pix[i+j])*alpha + 0.5)
+	}
+}
+
+// Function to rasterize the land (the union of all features) into a coverage
+// mask at the current projection and bounds
+func renderLandMask(fc *geojson.FeatureCollection, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) (*image.Alpha, error) {
+	return renderFeatureMask(fc.Features, opts, funcToScreen)
+}
+
+// Function to rasterize the union of the given features into a coverage mask
+func renderFeatureMask(features []*geojson.Feature, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) (*image.Alpha, error) {
+	// All rings go into a single path so shared borders leave no seams
+	var sb strings.Builder
+	for _, feature := range features {
+		for _, ring := range featureRings(feature) {
+			sb.WriteString(ringToPath(ring, funcToScreen, opts.tolerance) + " ")
+		}
+	}
+
+	buf := new(bytes.Buffer)
+	canvas := svg.New(buf)
+	canvas.Start(opts.width, opts.height)
+	canvas.Path(sb.String(), "fill:#ffffff;stroke:none")
+	canvas.End()
+
+	icon, err := oksvg.ReadIconStream(buf)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read icon stream: %w", err)
+	}
+	icon.SetTarget(0, 0, float64(opts.width), float64(opts.height))
+
+	mask := image.NewAlpha(image.Rect(0, 0, opts.width, opts.height))
+	scanner := rasterx.NewScannerGV(opts.width, opts.height, mask, mask.Bounds())
+	icon.Draw(rasterx.NewDasher(opts.width, opts.height, scanner), 1.0)
+	return mask, nil
+}
+
+// Function to convert a coverage mask into a single-channel grayscale image
+// where land is white and sea is black
+func maskToGray(mask *image.Alpha) *image.Gray {
+	gray := image.NewGray(mask.Bounds())
+	copy(gray.Pix, mask.Pix)
+	return gray
+}
+
+// Function to blur a coverage mask with three box blur passes, which closely
+// approximates a gaussian blur
+func blurAlpha(mask *image.Alpha, radius int) *image.Alpha {
+	bounds := mask.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	values := make([]float64, width*height)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			values[y*width+x] = float64(mask.Pix[y*mask.Stride+x])
+		}
+	}
+
+	tmp := make([]float64, len(values))
+	for pass := 0; pass < 3; pass++ {
+		boxBlur(values, tmp, width, height, radius, 1, width) // Horizontal
+		boxBlur(tmp, values, height, width, radius, width, 1) // Vertical
+	}
+
+	blurred := image.NewAlpha(bounds)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			blurred.Pix[y*blurred.Stride+x] = uint8(math.Min(255, values[y*width+x]+0.5))
+		}
+	}
+	return blurred
+}
+
+// Function to run a running-sum box blur over the lines of a buffer. step is
+// the distance between neighboring samples of a line and stride the distance
+// between lines.
+func boxBlur(src, dst []float64, length, lines, radius, step, stride int) {
+	window := float64(2*radius + 1)
+	for line := 0; line < lines; line++ {
+		base := line * stride
+		at := func(i int) float64 {
+			// Samples outside of the buffer count as empty
+			if i < 0 || i >= length {
+				return 0
+			}
+			return src[base+i*step]
+		}
+
+		sum := 0.0
+		for i := -radius; i <= radius; i++ {
+			sum += at(i)
+		}
+		for i := 0; i < length; i++ {
+			dst[base+i*step] = sum / window
+			sum += at(i+radius+1) - at(i-radius)
+		}
+	}
+}
+
+// Function to tint the sea with a gradient that gets lighter towards the
+// coastline, hinting at shallow water without real bathymetry data
+func applySeaGradient(img *image.RGBA, land *image.Alpha, radius int) {
+	shallow := color.RGBA{0x1e, 0x3a, 0x5f, 0xff}
+	depth := blurAlpha(land, radius)
+
+	bounds := img.Bounds()
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			// Strength near the coast, leaving the land itself untouched
+			near := float64(depth.AlphaAt(x, y).A) / 255
+			sea := 1 - float64(land.AlphaAt(x, y).A)/255
+			alpha := math.Min(1, near*1.5) * sea * 0.6
+			if alpha <= 0 {
+				continue
+			}
+			blendPixel(img, x, y, color.NRGBA{shallow.R, shallow.G, shallow.B, uint8(alpha*255 + 0.5)})
+		}
+	}
+}
+
+// Function to make the corners of the image transparent outside of a rounded
+// rectangle with the given radius
+func applyRoundedCorners(img *image.RGBA, radius float64) {
+	bounds := img.Bounds()
+	width := float64(bounds.Dx())
+	height := float64(bounds.Dy())
+	radius = math.Min(radius, math.Min(width, height)/2)
+
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		py := float64(y-bounds.Min.Y) + 0.5
+		// Only the rows within the radius of the top or bottom edge are affected
+		if py > radius && py < height-radius {
+			continue
+		}
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			px := float64(x-bounds.Min.X) + 0.5
+
+			// Distance from the center of the nearest corner arc
+			cx := math.Max(radius-px, px-(width-radius))
+			cy := math.Max(radius-py, py-(height-radius))
+			if cx <= 0 || cy <= 0 {
+				continue
+			}
+
+			// Anti-aliased coverage of the arc
+			alpha := math.Max(0, math.Min(1, radius-math.Hypot(cx, cy)+0.5))
+			if alpha < 1 {
+				scaleAlpha(img, x, y, alpha)
+			}
+		}
+	}
+}
+
+// Function to round off fine coastline detail. The land mask is blurred and
+// re-thresholded, then the sea that falls inside the smoothed coast takes the
+// color of the nearest land and the land outside of it becomes sea. The
+// smoothed mask is returned for the layers drawn afterwards.
+func generalizeLand(img *image.RGBA, land *image.Alpha, radius int, background color.RGBA) *image.Alpha {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+
+	// Re-threshold the blurred mask, keeping a one pixel wide soft edge. The
+	// blur spreads the coast over about 2.5 pixels per pixel of radius.
+	smooth := blurAlphaClamped(land, radius)
+	sharpen := math.Max(2, 2.5*float64(radius))
+	for i, v := range smooth.Pix {
+		smooth.Pix[i] = uint8(math.Max(0, math.Min(255, (float64(v)-127.5)*sharpen+127.5)))
+	}
+
+	// Spread the colors of the solid land outwards over the smoothed land
+	// with a breadth-first search, so every pixel gets its nearest land color
+	source := make([]int32, width*height)
+	queue := make([]int32, 0, width*height)
+	for i := range source {
+		source[i] = -1
+		if land.Pix[(i/width)*land.Stride+i%width] == 0xff {
+			source[i] = int32(i)
+			queue = append(queue, int32(i))
+		}
+	}
+	for head := 0; head < len(queue); head++ {
+		i := int(queue[head])
+		x, y := i%width, i/width
+		for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+			if n[0] < 0 || n[0] >= width || n[1] < 0 || n[1] >= height {
+				continue
+			}
+			j := n[1]*width + n[0]
+			if source[j] >= 0 || smooth.Pix[n[1]*smooth.Stride+n[0]] == 0 {
+				continue
+			}
+			source[j] = source[i]
+			queue = append(queue, int32(j))
+		}
+	}
+
+	// Colors are read from a copy since the source pixels may change too
+	original := image.NewRGBA(bounds)
+	copy(original.Pix, img.Pix)
+
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			i := y*width + x
+			coverage := smooth.Pix[y*smooth.Stride+x]
+			// The interior is kept as drawn, including the inner borders
+			if coverage == 0xff && land.Pix[y*land.Stride+x] == 0xff {
+				continue
+			}
+
+			c := background
+			if coverage > 0 && source[i] >= 0 {
+				s := int(source[i])
+				land := original.RGBAAt(bounds.Min.X+s%width, bounds.Min.Y+s/width)
+				c = blend(land, background, float64(coverage)/255)
+			}
+			img.SetRGBA(bounds.Min.X+x, bounds.Min.Y+y, c)
+		}
+	}
+	return smooth
+}
+
+// Function to blur a coverage mask like blurAlpha, but with the edge pixels
+// repeated outside of the mask so that shapes touching the edge keep their
+// full coverage there
+func blurAlphaClamped(mask *image.Alpha, radius int) *image.Alpha {
+	bounds := mask.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	pad := 3 * radius
+
+	padded := image.NewAlpha(image.Rect(0, 0, width+2*pad, height+2*pad))
+	for y := 0; y < height+2*pad; y++ {
+		sy := max(0, min(float64(height-1), float64(y-pad)))
+		for x := 0; x < width+2*pad; x++ {
+			sx := max(0, min(float64(width-1), float64(x-pad)))
+			padded.Pix[y*padded.Stride+x] = mask.Pix[int(sy)*mask.Stride+int(sx)]
+		}
+	}
+
+	blurred := blurAlpha(padded, radius)
+	result := image.NewAlpha(bounds)
+	for y := 0; y < height; y++ {
+		copy(result.Pix[y*result.Stride:y*result.Stride+width], blurred.Pix[(y+pad)*blurred.Stride+pad:])
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// Metrics of the render endpoints, registered with the default registry
+// and served on /metrics
+var (
+	renderRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "canvas_requests_total",
+		Help: "Render requests by output format and status code.",
+	}, []string{"format", "status"})
+
+	renderDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "canvas_render_duration_seconds",
+		Help:    "Time to answer a render request, encoding included.",
+		Buckets: []float64{0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"format"})
+
+	renderOutputBytes = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "canvas_output_bytes",
+		Help:    "Size of the successfully rendered output.",
+		Buckets: prometheus.ExponentialBuckets(1024, 4, 10),
+	}, []string{"format"})
+
+	// The base maps are loaded at startup and served from memory, while a
+	// geojsonUrl is fetched for every request
+	geojsonCache = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "canvas_geojson_cache_total",
+		Help: "GeoJSON lookups served from the loaded base maps (hit) or fetched remotely (miss).",
+	}, []string{"result"})
+
+	renderCacheLookups = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "canvas_render_cache_total",
+		Help: "Renders served from the render cache (hit) or rendered anew (miss).",
+	}, []string{"result"})
+)
+
+// Output formats used as metric labels. Anything else is counted as
+// "other" so that requests cannot create arbitrary label values.
+var metricFormats = map[string]bool{
+	"png": true, "jpeg": true, "webp": true, "gif": true, "mask": true,
+	"svg": true, "symbol": true, "topojson": true, "pdf": true, "datauri": true, "json": true,
+}
+
+// Function to record a finished render request in the metrics
+func observeRender(entry *accessLogEntry) {
+	format := entry.Format
+	if !metricFormats[format] {
+		format = "other"
+	}
+	renderRequests.WithLabelValues(format, strconv.Itoa(entry.Status)).Inc()
+	renderDuration.WithLabelValues(format).Observe(entry.DurationMs / 1000)
+	if entry.Status == http.StatusOK {
+		renderOutputBytes.WithLabelValues(format).Observe(float64(entry.Bytes))
+	}
+}
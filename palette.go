@@ -0,0 +1,72 @@
+package main
+
+// Color scheme of the intensities, returning the fill color of a scale
+type palette func(scale int) string
+
+// Selectable palettes, the default is the JMA style intensityToColor
+var palettes = map[string]palette{
+	"jma":     intensityToColor,
+	"viridis": viridisColor,
+	"cividis": cividisColor,
+}
+
+// Function to convert intensity scale to color with the viridis color map,
+// which stays distinguishable with color vision deficiencies
+func viridisColor(scale int) string {
+	switch scale {
+	case 1:
+		return "#482475"
+	case 2:
+		return "#3b528b"
+	case 3:
+		return "#2a788e"
+	case 4:
+		return "#21918c"
+	case 5:
+		return "#44bf70"
+	case 6:
+		return "#9bd93c"
+	case 7:
+		return "#fde725"
+	default:
+		// Above the scale keeps the brightest color, zero and below stay neutral
+		if scale > 7 {
+			return "#fde725"
+		}
+		return "#27272a"
+	}
+}
+
+// Function to convert intensity scale to color with the cividis color map,
+// which is designed to look alike with and without color vision deficiencies
+func cividisColor(scale int) string {
+	switch scale {
+	case 1:
+		return "#123570"
+	case 2:
+		return "#3b496c"
+	case 3:
+		return "#575d6d"
+	case 4:
+		return "#8a8678"
+	case 5:
+		return "#a59c74"
+	case 6:
+		return "#c3b369"
+	case 7:
+		return "#fee838"
+	default:
+		if scale > 7 {
+			return "#fee838"
+		}
+		return "#27272a"
+	}
+}
+
+// Function to get the fill color of the scale in the palette of the request
+func (opts renderOptions) intensityColor(scale int) string {
+	if opts.palette == nil {
+		return intensityToColor(scale)
+	}
+	return opts.palette(scale)
+}
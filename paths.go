@@ -0,0 +1,58 @@
+package main
+
+import (
+	"runtime"
+	"strings"
+	"sync"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Function to build the SVG path of a feature from all of its rings
+func featurePath(feature *geojson.Feature, funcToScreen func(float64, float64) (float64, float64), tolerance float64) string {
+	var sb strings.Builder
+	for _, polygon := range featurePolygons(feature) {
+		for _, ring := range polygon {
+			sb.WriteString(ringToPath(ring, funcToScreen, tolerance))
+			sb.WriteString(" ")
+		}
+	}
+	return sb.String()
+}
+
+// Function to build the SVG paths of the features, in the order of the
+// features. Detailed geometry makes this the slowest part of building the
+// SVG, so the features are shared out among up to GOMAXPROCS workers; the
+// caller still writes the paths to the canvas one at a time since svgo is not
+// safe for concurrent use.
+func featurePaths(features []*geojson.Feature, funcToScreen func(float64, float64) (float64, float64), tolerance float64) []string {
+	paths := make([]string, len(features))
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(features) {
+		workers = len(features)
+	}
+	if workers <= 1 {
+		for i, feature := range features {
+			paths[i] = featurePath(feature, funcToScreen, tolerance)
+		}
+		return paths
+	}
+
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				paths[i] = featurePath(features[i], funcToScreen, tolerance)
+			}
+		}()
+	}
+	for i := range features {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	return paths
+}
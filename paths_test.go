@@ -0,0 +1,25 @@
+package main
+
+import (
+	"fmt"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+// Paths of the whole embedded map of Japan at full detail, built by a single
+// worker and by a pool of one worker per CPU
+func BenchmarkFeaturePaths(b *testing.B) {
+	fc := baseMaps["japan"].features
+	minLon, minLat, maxLon, maxLat := calculateBounds(fc, nil)
+	project := newScreenProjection(minLon, minLat, maxLon, maxLat, 1280, 720, 0.1)
+
+	for _, workers := range slices.Compact([]int{1, runtime.NumCPU()}) {
+		b.Run(fmt.Sprintf("workers=%d", workers), func(b *testing.B) {
+			defer runtime.GOMAXPROCS(runtime.GOMAXPROCS(workers))
+			for b.Loop() {
+				featurePaths(fc.Features, project, 0)
+			}
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Hatching drawn over the fill of an intensity so that the levels can still
+// be told apart when printed in grayscale
+type hatch struct {
+	angles  []int   // Directions of the lines: 0 (horizontal), 90, 45 (/) or 135 (\)
+	dots    bool    // Dots instead of lines
+	spacing float64 // Distance between the lines or dots, in units of 8 pixels
+}
+
+// Hatchings of the intensities, denser and busier as the intensity grows
+var hatches = map[int]hatch{
+	1: {dots: true, spacing: 1.5},
+	2: {dots: true, spacing: 1},
+	3: {angles: []int{45}, spacing: 1.5},
+	4: {angles: []int{135}, spacing: 1},
+	5: {angles: []int{45, 135}, spacing: 1.5},
+	6: {angles: []int{0, 90}, spacing: 1},
+	7: {angles: []int{45, 135}, spacing: 0.75},
+}
+
+// Colors of the hatching lines and dots, the light one over dark fills
+var (
+	hatchDark  = color.RGBA{0x18, 0x18, 0x1b, 0xff}
+	hatchLight = color.RGBA{0xfa, 0xfa, 0xfa, 0xff}
+)
+
+// Function to get the color of the hatching of an intensity, contrasting
+// with its fill
+func (opts renderOptions) hatchColor(scale int) color.RGBA {
+	fill, err := hexToRGBA(opts.intensityColor(scale))
+	if err == nil && 0.299*float64(fill.R)+0.587*float64(fill.G)+0.114*float64(fill.B) < 80 {
+		return hatchLight
+	}
+	return hatchDark
+}
+
+// Function to get the id of the SVG pattern of an intensity
+func hatchID(scale int) string {
+	return fmt.Sprintf("hatch-%d", scale)
+}
+
+// Function to get the spacing, line width and dot radius of a hatching in
+// pixels
+func (h hatch) metrics(multiplier float64) (spacing, width, radius float64) {
+	return h.spacing * 8 * multiplier, 1.5 * multiplier, 1.5 * multiplier
+}
+
+// Function to check whether the hatching covers the pixel. The lines and
+// dots repeat with the spacing from the origin of the canvas, the same as
+// the SVG patterns in user space.
+func (h hatch) covers(x, y, multiplier float64) bool {
+	s, w, r := h.metrics(multiplier)
+	mod := func(v float64) float64 {
+		v = math.Mod(v, s)
+		if v < 0 {
+			v += s
+		}
+		return v
+	}
+	if h.dots {
+		dx, dy := mod(x)-s/2, mod(y)-s/2
+		return dx*dx+dy*dy <= r*r
+	}
+	for _, angle := range h.angles {
+		var d, limit float64
+		switch angle {
+		case 0:
+			d, limit = math.Abs(mod(y)-s/2), w/2
+		case 90:
+			d, limit = math.Abs(mod(x)-s/2), w/2
+		case 45:
+			d, limit = math.Min(mod(x+y), s-mod(x+y)), w/math.Sqrt2
+		case 135:
+			d, limit = math.Min(mod(x-y), s-mod(x-y)), w/math.Sqrt2
+		}
+		if d <= limit {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to write the SVG patterns of the hatchings, to be placed in the
+// definitions of the map
+func hatchPatterns(opts renderOptions) string {
+	var sb strings.Builder
+	for scale := 1; scale <= 7; scale++ {
+		h := hatches[scale]
+		s, w, r := h.metrics(opts.multiplier)
+		c := opts.hatchColor(scale)
+		fill := fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+		fmt.Fprintf(&sb, `<pattern id="%s" width="%g" height="%g" patternUnits="userSpaceOnUse">`, hatchID(scale), s, s)
+		if h.dots {
+			fmt.Fprintf(&sb, `<circle cx="%g" cy="%g" r="%g" fill="%s"/>`, s/2, s/2, r, fill)
+		}
+		for _, angle := range h.angles {
+			var d string
+			switch angle {
+			case 0:
+				d = fmt.Sprintf("M0,%g H%g", s/2, s)
+			case 90:
+				d = fmt.Sprintf("M%g,0 V%g", s/2, s)
+			case 45:
+				// The neighbouring lines cross the corners of the tile
+				d = fmt.Sprintf("M%g,%g L%g,%g M0,%g L%g,0 M%g,%g L%g,%g",
+					-s/2, s/2, s/2, -s/2, s, s, s/2, 3*s/2, 3*s/2, s/2)
+			case 135:
+				d = fmt.Sprintf("M%g,%g L%g,%g M0,0 L%g,%g M%g,%g L%g,%g",
+					s/2, -s/2, 3*s/2, s/2, s, s, -s/2, s/2, s/2, 3*s/2)
+			}
+			fmt.Fprintf(&sb, `<path d="%s" stroke="%s" stroke-width="%g"/>`, d, fill, w)
+		}
+		sb.WriteString("</pattern>")
+	}
+	return sb.String()
+}
+
+// Function to draw the hatching over the affected prefectures as SVG, using
+// the patterns in the definitions
+func drawHatchingSVG(canvas *svg.SVG, features []*geojson.Feature, paths []string, scaleMap map[int]int) {
+	for i, feature := range features {
+		scale := scaleMap[int(feature.Properties["id"].(float64))]
+		if _, ok := hatches[scale]; ok {
+			canvas.Path(paths[i], fmt.Sprintf("fill:url(#%s);stroke:none", hatchID(scale)))
+		}
+	}
+}
+
+// Function to draw the hatching over the affected prefectures of the
+// rendered image, since oksvg ignores patterns
+func drawHatching(img *image.RGBA, features []*geojson.Feature, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	groups := make(map[int][]*geojson.Feature)
+	for _, feature := range features {
+		scale := scaleMap[int(feature.Properties["id"].(float64))]
+		if _, ok := hatches[scale]; ok {
+			groups[scale] = append(groups[scale], feature)
+		}
+	}
+
+	scales := make([]int, 0, len(groups))
+	for scale := range groups {
+		scales = append(scales, scale)
+	}
+	sort.Ints(scales)
+
+	for _, scale := range scales {
+		mask, err := renderFeatureMask(groups[scale], opts, funcToScreen)
+		if err != nil {
+			return err
+		}
+		fillHatch(img, mask.Bounds(), hatches[scale], opts.hatchColor(scale), opts.multiplier, func(x, y int) uint8 { return mask.AlphaAt(x, y).A })
+	}
+	return nil
+}
+
+// Function to draw a hatching within the rectangle, weighted by the coverage
+// of each pixel
+func fillHatch(img *image.RGBA, rect image.Rectangle, h hatch, c color.RGBA, multiplier float64, coverage func(x, y int) uint8) {
+	rect = rect.Intersect(img.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			a := coverage(x, y)
+			if a == 0 || !h.covers(float64(x)+0.5, float64(y)+0.5, multiplier) {
+				continue
+			}
+			blendPixel(img, x, y, color.NRGBA{c.R, c.G, c.B, a})
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/go-pdf/fpdf"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Font family name of the text in the PDF
+const pdfFontFamily = "map"
+
+// Function to render the map as a single page PDF. The SVG of the map is
+// converted element by element, so the page keeps the vector shapes and the
+// text, with one point per pixel of the canvas. Only what buildSVG produces
+// is supported: rects, circles, paths made of M, L and Z, and text.
+func renderPDF(fc *geojson.FeatureCollection, scaleMap map[int]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) ([]byte, error) {
+	svgData, err := buildSVG(fc, scaleMap, opts, funcToScreen)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf := fpdf.NewCustom(&fpdf.InitType{
+		UnitStr: "pt",
+		Size:    fpdf.SizeType{Wd: float64(opts.width), Ht: float64(opts.height)},
+	})
+	pdf.SetMargins(0, 0, 0)
+	pdf.SetAutoPageBreak(false, 0)
+	pdf.AddPage()
+
+	fontBytes, err := readFontFile(opts.fontFile().file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	pdf.AddUTF8FontFromBytes(pdfFontFamily, "", fontBytes)
+
+	if err := drawSVGToPDF(pdf, svgData, opts); err != nil {
+		return nil, err
+	}
+
+	// The footer is drawn onto the raster output only, so it is added here
+	pdf.SetAlpha(1, "Normal")
+	pdf.SetFont(pdfFontFamily, "", opts.footerHeight())
+	pdf.SetTextColor(int(opts.footerColor.R), int(opts.footerColor.G), int(opts.footerColor.B))
+	lines := opts.footerLines()
+	for i, line := range lines {
+		above := float64(len(lines)-1-i) * footerLineSpacing * opts.footerHeight()
+		line = truncateText(line, opts.footerMaxWidth(), pdf.GetStringWidth)
+		pdf.Text(10*opts.multiplier, float64(opts.height)-opts.footerHeight()-above, line)
+	}
+
+	var buf bytes.Buffer
+	if err := pdf.Output(&buf); err != nil {
+		return nil, fmt.Errorf("failed to write pdf: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// Function to draw the elements of the SVG onto the current PDF page. The
+// client definitions are skipped, fills referring to them are left out. The
+// hatching patterns are drawn directly since the page has no patterns.
+func drawSVGToPDF(pdf *fpdf.Fpdf, svgData []byte, opts renderOptions) error {
+	decoder := xml.NewDecoder(bytes.NewReader(svgData))
+	inDefs := 0
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read svg: %w", err)
+		}
+
+		if end, ok := token.(xml.EndElement); ok && end.Name.Local == "defs" {
+			inDefs--
+		}
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if start.Name.Local == "defs" {
+			inDefs++
+		}
+		if inDefs > 0 {
+			continue
+		}
+
+		attrs := make(map[string]string, len(start.Attr))
+		for _, attr := range start.Attr {
+			attrs[attr.Name.Local] = attr.Value
+		}
+		style := parseSVGStyle(attrs["style"])
+		number := func(name string) float64 {
+			v, _ := strconv.ParseFloat(attrs[name], 64)
+			return v
+		}
+
+		switch start.Name.Local {
+		case "rect":
+			x, y, width, height := number("x"), number("y"), number("width"), number("height")
+			outline := func() {
+				pdf.MoveTo(x, y)
+				pdf.LineTo(x+width, y)
+				pdf.LineTo(x+width, y+height)
+				pdf.LineTo(x, y+height)
+				pdf.ClosePath()
+			}
+			if !hatchPDF(pdf, style, opts, x, y, x+width, y+height, outline) {
+				paintPDF(pdf, style, outline)
+			}
+		case "circle":
+			cx, cy, r := number("cx"), number("cy"), number("r")
+			paintPDF(pdf, style, func() {
+				pdf.MoveTo(cx+r, cy)
+				pdf.ArcTo(cx, cy, r, r, 0, 0, 360)
+				pdf.ClosePath()
+			})
+		case "path":
+			commands, err := parsePathData(attrs["d"])
+			if err != nil {
+				return err
+			}
+			outline := func() {
+				for _, c := range commands {
+					switch c.op {
+					case 'M':
+						pdf.MoveTo(c.x, c.y)
+					case 'L':
+						pdf.LineTo(c.x, c.y)
+					case 'Z':
+						pdf.ClosePath()
+					}
+				}
+			}
+			minX, minY := math.Inf(1), math.Inf(1)
+			maxX, maxY := math.Inf(-1), math.Inf(-1)
+			for _, c := range commands {
+				if c.op != 'Z' {
+					minX, minY = math.Min(minX, c.x), math.Min(minY, c.y)
+					maxX, maxY = math.Max(maxX, c.x), math.Max(maxY, c.y)
+				}
+			}
+			if !hatchPDF(pdf, style, opts, minX, minY, maxX, maxY, outline) {
+				paintPDF(pdf, style, outline)
+			}
+		case "text":
+			var text string
+			if err := decoder.DecodeElement(&text, &start); err != nil {
+				return fmt.Errorf("failed to read svg text: %w", err)
+			}
+			fill, ok := style.color("fill")
+			if !ok {
+				continue
+			}
+			size, _ := strconv.ParseFloat(strings.TrimSuffix(style["font-size"], "px"), 64)
+			pdf.SetAlpha(style.opacity("fill-opacity"), "Normal")
+			pdf.SetFont(pdfFontFamily, "", size)
+			pdf.SetTextColor(fill[0], fill[1], fill[2])
+			pdf.Text(number("x"), number("y"), strings.TrimSpace(text))
+		}
+	}
+	return nil
+}
+
+// Declarations of an SVG style attribute
+type svgStyle map[string]string
+
+// Function to parse an SVG style attribute ("fill:#fff;stroke:none")
+func parseSVGStyle(value string) svgStyle {
+	style := svgStyle{}
+	for _, declaration := range strings.Split(value, ";") {
+		name, v, found := strings.Cut(declaration, ":")
+		if found {
+			style[strings.TrimSpace(name)] = strings.TrimSpace(v)
+		}
+	}
+	return style
+}
+
+// Function to get a color property as RGB, reporting false for "none" and
+// for anything that is not a hex color such as references to definitions
+func (s svgStyle) color(name string) ([3]int, bool) {
+	c, err := hexToRGBA(s[name])
+	if err != nil {
+		return [3]int{}, false
+	}
+	return [3]int{int(c.R), int(c.G), int(c.B)}, true
+}
+
+// Function to get an opacity property combined with the overall opacity
+func (s svgStyle) opacity(name string) float64 {
+	opacity := 1.0
+	for _, property := range []string{name, "opacity"} {
+		if v, err := strconv.ParseFloat(s[property], 64); err == nil {
+			opacity *= v
+		}
+	}
+	return opacity
+}
+
+// Function to fill and then stroke the shape traced by outline, each with
+// its own opacity. SVG fills black unless told otherwise.
+func paintPDF(pdf *fpdf.Fpdf, style svgStyle, outline func()) {
+	fill, hasFill := style.color("fill")
+	if _, set := style["fill"]; !set {
+		hasFill = true
+	}
+	if hasFill {
+		pdf.SetAlpha(style.opacity("fill-opacity"), "Normal")
+		pdf.SetFillColor(fill[0], fill[1], fill[2])
+		outline()
+		pdf.DrawPath("F")
+	}
+
+	stroke, hasStroke := style.color("stroke")
+	width, _ := strconv.ParseFloat(style["stroke-width"], 64)
+	if !hasStroke || width <= 0 {
+		return
+	}
+	pdf.SetAlpha(style.opacity("stroke-opacity"), "Normal")
+	pdf.SetDrawColor(stroke[0], stroke[1], stroke[2])
+	pdf.SetLineWidth(width)
+	pdf.SetLineCapStyle(style.or("stroke-linecap", "butt"))
+	pdf.SetLineJoinStyle(style.or("stroke-linejoin", "miter"))
+	outline()
+	pdf.DrawPath("D")
+}
+
+// Function to draw the hatching of a fill referring to a hatching pattern
+// (url(#hatch-N)) within the shape traced by outline, reporting false for
+// other fills. The lines and dots are laid out like the SVG patterns over the
+// bounds of the shape and clipped to it with raw operators, since fpdf only
+// clips to polygons of a single ring.
+func hatchPDF(pdf *fpdf.Fpdf, style svgStyle, opts renderOptions, minX, minY, maxX, maxY float64, outline func()) bool {
+	var scale int
+	if _, err := fmt.Sscanf(style["fill"], "url(#hatch-%d)", &scale); err != nil {
+		return false
+	}
+	h, ok := hatches[scale]
+	if !ok || minX > maxX || minY > maxY {
+		return true
+	}
+	s, width, radius := h.metrics(opts.multiplier)
+	c := opts.hatchColor(scale)
+
+	// The state set within the clip is dropped at its end, so fpdf is told
+	// the previous one again afterwards
+	fillR, fillG, fillB := pdf.GetFillColor()
+	drawR, drawG, drawB := pdf.GetDrawColor()
+	lineWidth := pdf.GetLineWidth()
+	defer func() {
+		pdf.SetFillColor(fillR, fillG, fillB)
+		pdf.SetDrawColor(drawR, drawG, drawB)
+		pdf.SetLineWidth(lineWidth)
+	}()
+
+	pdf.SetAlpha(1, "Normal")
+	pdf.RawWriteStr("q")
+	outline()
+	pdf.RawWriteStr("W n")
+	pdf.SetFillColor(int(c.R), int(c.G), int(c.B))
+	pdf.SetDrawColor(int(c.R), int(c.G), int(c.B))
+	pdf.SetLineWidth(width)
+
+	// Offsets k*s within [from, to], where k is an integer
+	steps := func(from, to float64) []float64 {
+		var offsets []float64
+		for k := math.Floor(from / s); k*s <= to; k++ {
+			offsets = append(offsets, k*s)
+		}
+		return offsets
+	}
+	if h.dots {
+		for _, y := range steps(minY-s, maxY) {
+			for _, x := range steps(minX-s, maxX) {
+				pdf.Circle(x+s/2, y+s/2, radius, "F")
+			}
+		}
+	}
+	for _, angle := range h.angles {
+		switch angle {
+		case 0:
+			for _, y := range steps(minY-s, maxY) {
+				pdf.Line(minX, y+s/2, maxX, y+s/2)
+			}
+		case 90:
+			for _, x := range steps(minX-s, maxX) {
+				pdf.Line(x+s/2, minY, x+s/2, maxY)
+			}
+		case 45:
+			// Lines where x+y is a multiple of the spacing
+			for _, k := range steps(minX+minY, maxX+maxY) {
+				pdf.Line(minX, k-minX, maxX, k-maxX)
+			}
+		case 135:
+			// Lines where x-y is a multiple of the spacing
+			for _, k := range steps(minX-maxY, maxX-minY) {
+				pdf.Line(minX, minX-k, maxX, maxX-k)
+			}
+		}
+	}
+	pdf.RawWriteStr("Q")
+	return true
+}
+
+// Function to get a property, or the fallback when it is not set
+func (s svgStyle) or(name, fallback string) string {
+	if v, ok := s[name]; ok {
+		return v
+	}
+	return fallback
+}
+
+// Command of an SVG path
+type pathCommand struct {
+	op   byte
+	x, y float64
+}
+
+// Function to parse the data of an SVG path made of absolute M, L and Z
+// commands, which is all buildSVG emits
+func parsePathData(d string) ([]pathCommand, error) {
+	fields := strings.FieldsFunc(d, func(r rune) bool { return r == ' ' || r == ',' || r == '\n' || r == '\t' })
+	var commands []pathCommand
+	var op byte
+	for i := 0; i < len(fields); i++ {
+		field := fields[i]
+		if c := field[0]; c >= 'A' && c <= 'Z' || c >= 'a' && c <= 'z' {
+			op, field = c, field[1:]
+			if op == 'Z' || op == 'z' {
+				op = 'Z'
+				commands = append(commands, pathCommand{op: 'Z'})
+				continue
+			}
+			if op != 'M' && op != 'L' {
+				return nil, fmt.Errorf("unsupported svg path command %q", op)
+			}
+			if field == "" {
+				i++
+				if i >= len(fields) {
+					return nil, errors.New("invalid svg path")
+				}
+				field = fields[i]
+			}
+		}
+		if op == 0 || op == 'Z' || i+1 >= len(fields) {
+			return nil, errors.New("invalid svg path")
+		}
+		x, errX := strconv.ParseFloat(field, 64)
+		y, errY := strconv.ParseFloat(fields[i+1], 64)
+		if errX != nil || errY != nil {
+			return nil, errors.New("invalid svg path")
+		}
+		i++
+		commands = append(commands, pathCommand{op, x, y})
+		// Further points after a move are lines
+		if op == 'M' {
+			op = 'L'
+		}
+	}
+	return commands, nil
+}
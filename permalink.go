@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// Maximum size of the query string a permalink token expands to
+const maxPermalinkSize = 64 << 10
+
+// Function to pack render parameters into an opaque URL-safe token. The
+// parameters are compressed into the token itself, so tokens never expire
+// and nothing has to be stored on the server.
+func encodePermalink(values url.Values) (string, error) {
+	params := url.Values{}
+	for k, v := range values {
+		if k != "t" {
+			params[k] = v
+		}
+	}
+
+	var buf bytes.Buffer
+	zw, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	// Encode sorts the keys, so equal parameters give equal tokens
+	if _, err := zw.Write([]byte(params.Encode())); err != nil {
+		return "", err
+	}
+	if err := zw.Close(); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// Function to unpack the render parameters of a permalink token
+func decodePermalink(token string) (url.Values, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+
+	zr := flate.NewReader(bytes.NewReader(data))
+	defer zr.Close()
+	query, err := io.ReadAll(io.LimitReader(zr, maxPermalinkSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("malformed token: %w", err)
+	}
+	if len(query) > maxPermalinkSize {
+		return nil, fmt.Errorf("token expands beyond %d bytes", maxPermalinkSize)
+	}
+	return url.ParseQuery(string(query))
+}
+
+// Function to replace the t parameter of the request with the parameters
+// packed into the token. Parameters given next to the token take precedence.
+func expandPermalink(r *http.Request) error {
+	query := r.URL.Query()
+	token := query.Get("t")
+	if token == "" {
+		return nil
+	}
+
+	values, err := decodePermalink(token)
+	if err != nil {
+		return err
+	}
+	for k, v := range query {
+		if k != "t" {
+			values[k] = v
+		}
+	}
+	r.URL.RawQuery = values.Encode()
+	return nil
+}
+
+// Handler returning a permalink token for the render parameters in the query
+func permalinkHandler(w http.ResponseWriter, r *http.Request) {
+	token, err := encodePermalink(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create permalink: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"token": token,
+		"url":   "/map?t=" + token,
+	})
+}
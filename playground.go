@@ -0,0 +1,118 @@
+package main
+
+import (
+	"html/template"
+	"log"
+	"maps"
+	"net/http"
+	"slices"
+)
+
+// Output formats offered by the playground, the first one is selected
+var playgroundFormats = []string{"png", "jpeg", "webp", "gif", "svg", "pdf", "json", "topojson"}
+
+// Page of the playground. The query is built in the browser from the form
+// and the render is fetched from /map, so that errors show as text.
+var playgroundTemplate = template.Must(template.New("playground").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>canvas playground</title>
+<style>
+body { margin: 0; display: flex; gap: 16px; padding: 16px; font: 14px sans-serif; background: #18181b; color: #fafafa; }
+form { display: flex; flex-direction: column; gap: 8px; width: 320px; flex: none; }
+textarea { height: 200px; font: 12px monospace; }
+textarea, input, select, button { background: #27272a; color: #fafafa; border: 1px solid #3f3f46; padding: 4px; }
+#output { flex: 1; min-width: 0; }
+#output img, #output iframe { max-width: 100%; border: 1px solid #3f3f46; }
+#output iframe { width: 100%; height: 80vh; }
+#output pre { white-space: pre-wrap; word-break: break-all; }
+.error { color: #f87171; }
+</style>
+</head>
+<body>
+<form id="form">
+<label>Scale (JSON or id:scale pairs)
+<textarea name="scale">[{"id": 13, "scale": 5}, {"id": 14, "scale": 3}, {"id": 11, "scale": 2}]</textarea></label>
+<label>Format <select name="format">{{range .Formats}}<option>{{.}}</option>{{end}}</select></label>
+<label>Width <input name="width" type="number" min="1" max="8192" placeholder="1280"></label>
+<label>Height <input name="height" type="number" min="1" max="8192" placeholder="720"></label>
+<label>Palette <select name="palette">{{range .Palettes}}<option>{{.}}</option>{{end}}</select></label>
+<label>Map <select name="map">{{range .Maps}}<option{{if eq . $.DefaultMap}} selected{{end}}>{{.}}</option>{{end}}</select></label>
+<label>Theme <select name="theme">{{range .Themes}}<option{{if eq . $.DefaultTheme}} selected{{end}}>{{.}}</option>{{end}}</select></label>
+<label>Other parameters <input name="extra" placeholder="legend=topright&amp;labels=true"></label>
+<button type="submit">Render</button>
+<a id="link" href="#"></a>
+</form>
+<div id="output"></div>
+<script>
+const form = document.getElementById("form");
+const output = document.getElementById("output");
+const link = document.getElementById("link");
+let objectURL;
+
+form.addEventListener("submit", async (event) => {
+  event.preventDefault();
+  const data = new FormData(form);
+  const params = new URLSearchParams(data.get("extra"));
+  for (const name of ["scale", "format", "width", "height", "palette", "map", "theme"]) {
+    const value = data.get(name).trim();
+    if (value !== "") {
+      params.set(name, value);
+    }
+  }
+  const url = "/map?" + params.toString();
+  link.href = url;
+  link.textContent = url;
+
+  output.textContent = "Rendering...";
+  const response = await fetch(url);
+  if (objectURL) {
+    URL.revokeObjectURL(objectURL);
+    objectURL = undefined;
+  }
+  const type = response.headers.get("Content-Type") || "";
+  if (!response.ok || type.startsWith("text/") || type.startsWith("application/json")) {
+    const pre = document.createElement("pre");
+    pre.textContent = await response.text();
+    pre.className = response.ok ? "" : "error";
+    output.replaceChildren(pre);
+    return;
+  }
+  objectURL = URL.createObjectURL(await response.blob());
+  const element = document.createElement(type === "application/pdf" ? "iframe" : "img");
+  element.src = objectURL;
+  output.replaceChildren(element);
+});
+</script>
+</body>
+</html>
+`))
+
+// Serves a page for trying out the renders by hand: the scale, format,
+// dimensions and palette are picked in a form and the result of /map is
+// shown inline
+func playgroundHandler(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Formats, Palettes, Maps, Themes []string
+		DefaultMap, DefaultTheme        string
+	}{
+		Formats:      playgroundFormats,
+		Palettes:     []string{"jma"},
+		Maps:         slices.Sorted(maps.Keys(baseMaps)),
+		Themes:       slices.Sorted(maps.Keys(themes)),
+		DefaultMap:   defaultBaseMap,
+		DefaultTheme: defaultTheme,
+	}
+	// The default palette comes first
+	for _, name := range slices.Sorted(maps.Keys(palettes)) {
+		if name != "jma" {
+			data.Palettes = append(data.Palettes, name)
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := playgroundTemplate.Execute(w, data); err != nil {
+		log.Printf("failed to render playground: %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"fmt"
+	"math"
+)
+
+// Projection of a point in degrees onto a plane, with y pointing north. The
+// units are arbitrary since the result is fitted to the canvas afterwards.
+type planarProjection func(lon, lat float64) (x, y float64)
+
+// Conversion of a point in degrees to canvas pixels, fitted to the bounds
+// and canvas size it was created for
+type Projector func(lon, lat float64) (x, y float64)
+
+// Project converts the point in degrees to canvas pixels
+func (p Projector) Project(lon, lat float64) (x, y float64) {
+	return p(lon, lat)
+}
+
+// Latitude limit of Web Mercator, where the map becomes square
+const maxMercatorLat = 85.05112878
+
+// Latitude span in degrees up to which the default projection keeps shapes
+// recognizable. Taller bounds default to the Lambert conformal conic.
+const maxEquirectangularSpan = 8.0
+
+// Function to check whether the projection is supported
+func isProjection(name string) bool {
+	switch name {
+	case "equirectangular", "mercator", "lcc", "albers":
+		return true
+	}
+	return false
+}
+
+// Function to create a projection from its name, standard parallels and
+// central meridian (all in degrees). The parallels are ignored by the
+// cylindrical projections.
+func newPlanarProjection(name string, lat1, lat2, lon0 float64) (planarProjection, error) {
+	const rad = math.Pi / 180
+	phi1, phi2 := lat1*rad, lat2*rad
+
+	switch name {
+	case "equirectangular":
+		// Distances along the parallels are true at the standard parallels
+		k := math.Cos((phi1 + phi2) / 2)
+		return func(lon, lat float64) (float64, float64) {
+			return (lon - lon0) * rad * k, lat * rad
+		}, nil
+
+	case "mercator":
+		// Same transform as web tile maps, so overlays line up with them
+		return func(lon, lat float64) (float64, float64) {
+			phi := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, lat)) * rad
+			return (lon - lon0) * rad, math.Log(math.Tan(math.Pi/4 + phi/2))
+		}, nil
+
+	case "lcc":
+		t := func(phi float64) float64 { return math.Tan(math.Pi/4 + phi/2) }
+		n := math.Sin(phi1)
+		if math.Abs(phi1-phi2) > 1e-9 {
+			n = math.Log(math.Cos(phi1)/math.Cos(phi2)) / math.Log(t(phi2)/t(phi1))
+		}
+		if math.Abs(n) < 1e-9 {
+			return nil, fmt.Errorf("standard parallels must not be symmetric around the equator")
+		}
+		f := math.Cos(phi1) * math.Pow(t(phi1), n) / n
+		return func(lon, lat float64) (float64, float64) {
+			// Keep away from the pole opposite to the cone's apex
+			phi := math.Max(-89, math.Min(89, lat)) * rad
+			rho := f / math.Pow(t(phi), n)
+			theta := n * (lon - lon0) * rad
+			return rho * math.Sin(theta), -rho * math.Cos(theta)
+		}, nil
+
+	case "albers":
+		n := (math.Sin(phi1) + math.Sin(phi2)) / 2
+		if math.Abs(n) < 1e-9 {
+			return nil, fmt.Errorf("standard parallels must not be symmetric around the equator")
+		}
+		c := math.Cos(phi1)*math.Cos(phi1) + 2*n*math.Sin(phi1)
+		return func(lon, lat float64) (float64, float64) {
+			rho := math.Sqrt(math.Max(0, c-2*n*math.Sin(lat*rad))) / n
+			theta := n * (lon - lon0) * rad
+			return rho * math.Sin(theta), -rho * math.Cos(theta)
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown projection: %s", name)
+}
+
+// Function to fit a projection into the canvas so that the bounds are
+// centered with the given margin, like the default projection
+func fitProjection(project planarProjection, minLon, minLat, maxLon, maxLat, width, height, margin float64) Projector {
+	// Conic projections bend the parallels, so the edges of the bounds are
+	// sampled instead of only the corners
+	const steps = 16
+	minX, minY := math.Inf(1), math.Inf(1)
+	maxX, maxY := math.Inf(-1), math.Inf(-1)
+	for i := 0; i <= steps; i++ {
+		t := float64(i) / steps
+		lon := minLon + (maxLon-minLon)*t
+		lat := minLat + (maxLat-minLat)*t
+		for _, p := range [][2]float64{{lon, minLat}, {lon, maxLat}, {minLon, lat}, {maxLon, lat}} {
+			x, y := project(p[0], p[1])
+			minX, minY = math.Min(minX, x), math.Min(minY, y)
+			maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+		}
+	}
+
+	scale := math.Min(width*(1-2*margin)/(maxX-minX), height*(1-2*margin)/(maxY-minY))
+	centerX, centerY := (minX+maxX)/2, (minY+maxY)/2
+
+	return func(lon, lat float64) (float64, float64) {
+		x, y := project(lon, lat)
+		return (x-centerX)*scale + width/2, (centerY-y)*scale + height/2
+	}
+}
+
+// Function to create the projector for the bounds and canvas size, leaving
+// margin (a share of the canvas) empty on each side. Without a projection
+// the default one is used, which scales the longitudes by the cosine of the
+// center latitude.
+func newProjector(projection planarProjection, minLon, minLat, maxLon, maxLat, width, height, margin float64) Projector {
+	if projection == nil {
+		return newScreenProjection(minLon, minLat, maxLon, maxLat, width, height, margin)
+	}
+	return fitProjection(projection, minLon, minLat, maxLon, maxLat, width, height, margin)
+}
+
+// Function to create the projector of the request for the given bounds and
+// canvas size. With recenter set the map is moved so that opts.center is in
+// the middle of the canvas.
+func (opts renderOptions) screenProjection(minLon, minLat, maxLon, maxLat, width, height float64) Projector {
+	fitWidth, fitHeight := width, height
+	if opts.rotate == 90 || opts.rotate == 270 {
+		// The map is fitted sideways, then turned onto the canvas
+		fitWidth, fitHeight = height, width
+	}
+	project := newProjector(opts.projection, minLon, minLat, maxLon, maxLat, fitWidth, fitHeight, opts.margin)
+	project = rotateProjector(project, opts.rotate, width, height)
+	if opts.recenter {
+		project = centerProjector(project, opts.center[0], opts.center[1], width, height)
+	}
+	return project
+}
+
+// Function to shift the output of a projector so that the point lands in
+// the middle of a canvas of the given size, keeping the scale it was fitted
+// with
+func centerProjector(project Projector, lon, lat, width, height float64) Projector {
+	x0, y0 := project(lon, lat)
+	dx, dy := width/2-x0, height/2-y0
+	return func(lon, lat float64) (float64, float64) {
+		x, y := project(lon, lat)
+		return x + dx, y + dy
+	}
+}
+
+// Function to turn the output of a projector clockwise by 0, 90, 180 or 270
+// degrees onto a canvas of the given size. For quarter turns the projector
+// must have been fitted to the canvas with its sides swapped. Only the map
+// turns, the text and overlays drawn at projected points stay upright.
+func rotateProjector(project Projector, degrees int, width, height float64) Projector {
+	switch degrees {
+	case 90:
+		return func(lon, lat float64) (float64, float64) {
+			x, y := project(lon, lat)
+			return width - y, x
+		}
+	case 180:
+		return func(lon, lat float64) (float64, float64) {
+			x, y := project(lon, lat)
+			return width - x, height - y
+		}
+	case 270:
+		return func(lon, lat float64) (float64, float64) {
+			x, y := project(lon, lat)
+			return y, height - x
+		}
+	}
+	return project
+}
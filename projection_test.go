@@ -0,0 +1,110 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestScreenProjection(t *testing.T) {
+	type point struct{ lon, lat, x, y float64 }
+	tests := []struct {
+		name                           string
+		minLon, minLat, maxLon, maxLat float64
+		margin                         float64
+		points                         []point
+	}{
+		{
+			// On the equator a degree is as wide as it is tall
+			name:   "equator",
+			minLon: 130, minLat: -5, maxLon: 140, maxLat: 5,
+			points: []point{
+				{135, 0, 500, 500},
+				{130, 5, 0, 0},
+				{140, -5, 1000, 1000},
+				{131, 0, 100, 500},
+			},
+		},
+		{
+			name:   "margin",
+			minLon: 130, minLat: -5, maxLon: 140, maxLat: 5,
+			margin: 0.1,
+			points: []point{
+				{135, 0, 500, 500},
+				{130, 5, 100, 100},
+				{140, -5, 900, 900},
+			},
+		},
+		{
+			// At 60° a degree of longitude is half as wide as a degree of
+			// latitude is tall, so the latitudes fill the canvas
+			name:   "latitude correction",
+			minLon: 0, minLat: 55, maxLon: 10, maxLat: 65,
+			points: []point{
+				{5, 60, 500, 500},
+				{10, 60, 750, 500},
+				{0, 60, 250, 500},
+				{5, 65, 500, 0},
+				{5, 55, 500, 1000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		project := newScreenProjection(tt.minLon, tt.minLat, tt.maxLon, tt.maxLat, 1000, 1000, tt.margin)
+		for _, p := range tt.points {
+			x, y := project.Project(p.lon, p.lat)
+			if math.Abs(x-p.x) > 1e-6 || math.Abs(y-p.y) > 1e-6 {
+				t.Errorf("%s: Project(%v, %v) = (%v, %v), want (%v, %v)", tt.name, p.lon, p.lat, x, y, p.x, p.y)
+			}
+		}
+	}
+}
+
+func TestFitProjection(t *testing.T) {
+	equirectangular, err := newPlanarProjection("equirectangular", 0, 0, 135)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mercator, err := newPlanarProjection("mercator", 0, 0, 135)
+	if err != nil {
+		t.Fatal(err)
+	}
+	type point struct{ lon, lat, x, y float64 }
+	tests := []struct {
+		name    string
+		project planarProjection
+		points  []point
+	}{
+		{
+			// Standard parallels on the equator match the default projection
+			name:    "equirectangular",
+			project: equirectangular,
+			points: []point{
+				{135, 0, 500, 500},
+				{130, 5, 0, 0},
+				{140, -5, 1000, 1000},
+			},
+		},
+		{
+			// The latitudes stretch towards the poles, so they fill the
+			// height and the bounds stay centered
+			name:    "mercator",
+			project: mercator,
+			points: []point{
+				{135, 0, 500, 500},
+				{135, 5, 500, 0},
+				{135, -5, 500, 1000},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		project := fitProjection(tt.project, 130, -5, 140, 5, 1000, 1000, 0)
+		for _, p := range tt.points {
+			x, y := project.Project(p.lon, p.lat)
+			if math.Abs(x-p.x) > 1e-6 || math.Abs(y-p.y) > 1e-6 {
+				t.Errorf("%s: Project(%v, %v) = (%v, %v), want (%v, %v)", tt.name, p.lon, p.lat, x, y, p.x, p.y)
+			}
+		}
+	}
+}
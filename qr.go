@@ -0,0 +1,80 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"net/url"
+
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+// Function to check whether the QR code position is supported
+func isCornerPosition(position string) bool {
+	switch position {
+	case "topleft", "topright", "bottomleft", "bottomright":
+		return true
+	}
+	return false
+}
+
+// Function to validate the URL encoded into the QR code
+func validateQRURL(value string) error {
+	if len(value) > 512 {
+		return fmt.Errorf("url is too long (%d > 512)", len(value))
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("url must be an absolute http(s) url")
+	}
+	return nil
+}
+
+// Function to draw a QR code linking to content in a corner of the image.
+// Modules are drawn with a whole number of pixels so the code stays sharp,
+// which means the code can end up slightly smaller than size.
+func drawQRCode(img *image.RGBA, content string, size int, position string, margin int) error {
+	q, err := qrcode.New(content, qrcode.Medium)
+	if err != nil {
+		return fmt.Errorf("failed to encode qr code: %w", err)
+	}
+
+	// The bitmap includes the quiet zone, which keeps the code readable
+	// on top of the dark map
+	bitmap := q.Bitmap()
+	module := size / len(bitmap)
+	if module < 1 {
+		module = 1
+	}
+	actual := module * len(bitmap)
+
+	bounds := img.Bounds()
+	x, y := bounds.Min.X+margin, bounds.Min.Y+margin
+	switch position {
+	case "topright":
+		x = bounds.Max.X - margin - actual
+	case "bottomleft":
+		y = bounds.Max.Y - margin - actual
+	case "bottomright":
+		x = bounds.Max.X - margin - actual
+		y = bounds.Max.Y - margin - actual
+	}
+
+	white := image.NewUniform(color.White)
+	black := image.NewUniform(color.Black)
+	draw.Draw(img, image.Rect(x, y, x+actual, y+actual), white, image.Point{}, draw.Src)
+	for row, line := range bitmap {
+		for col, set := range line {
+			if !set {
+				continue
+			}
+			rect := image.Rect(x+col*module, y+row*module, x+(col+1)*module, y+(row+1)*module)
+			draw.Draw(img, rect, black, image.Point{}, draw.Src)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/srwiley/rasterx"
+)
+
+// Scanner that renders shapes without anti-aliasing. Each path is first
+// rasterized into a coverage mask, which is then thresholded so that every
+// pixel is either fully painted or left untouched.
+type aliasedScanner struct {
+	*rasterx.ScannerGV
+	dst  *image.RGBA
+	mask *image.Alpha
+}
+
+func newAliasedScanner(width, height int, dst *image.RGBA) *aliasedScanner {
+	mask := image.NewAlpha(dst.Bounds())
+	return &aliasedScanner{
+		ScannerGV: rasterx.NewScannerGV(width, height, mask, mask.Bounds()),
+		dst:       dst,
+		mask:      mask,
+	}
+}
+
+// Draw renders the accumulated path onto the destination
+func (s *aliasedScanner) Draw() {
+	// Render the coverage of the path with an opaque source
+	src := s.Source
+	s.Source = image.Opaque
+	s.ScannerGV.Draw()
+	s.Source = src
+
+	extent := s.GetPathExtent()
+	rect := image.Rect(extent.Min.X.Floor(), extent.Min.Y.Floor(),
+		extent.Max.X.Ceil()+1, extent.Max.Y.Ceil()+1).Intersect(s.mask.Bounds())
+
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			coverage := s.mask.AlphaAt(x, y).A
+			if coverage == 0 {
+				continue
+			}
+			if coverage >= 0x80 {
+				blendPixel(s.dst, x, y, src.At(x, y))
+			}
+			s.mask.SetAlpha(x, y, color.Alpha{})
+		}
+	}
+}
+
+// Function to composite a color over a pixel of the image
+func blendPixel(img *image.RGBA, x, y int, c color.Color) {
+	sr, sg, sb, sa := c.RGBA()
+	if sa == 0 {
+		return
+	}
+	i := img.PixOffset(x, y)
+	pix := img.Pix[i : i+4 : i+4]
+	inv := 0xffff - sa
+	pix[0] = uint8((uint32(pix[0])*0x101*inv/0xffff + sr) >> 8)
+	pix[1] = uint8((uint32(pix[1])*0x101*inv/0xffff + sg) >> 8)
+	pix[2] = uint8((uint32(pix[2])*0x101*inv/0xffff + sb) >> 8)
+	pix[3] = uint8((uint32(pix[3])*0x101*inv/0xffff + sa) >> 8)
+}
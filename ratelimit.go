@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// Burst allowed when RATE_BURST is not set
+const defaultRateBurst = 5
+
+// Time after which the limiter of an idle client is dropped
+const rateLimitIdle = 5 * time.Minute
+
+// Per-client rate limit of the render endpoints, configured with RATE_LIMIT
+// (requests per second) and RATE_BURST. Rendering is CPU-intensive, so a
+// single client could otherwise starve the others. Nil disables it.
+var renderLimiter *clientLimiter
+
+// Reverse proxies in front of the server, configured with TRUSTED_PROXIES as
+// a comma-separated list of IPs or CIDR ranges. Requests from them are
+// limited by the client in X-Forwarded-For instead of the proxy. Empty by
+// default, since any client can send the header itself.
+var trustedProxies []netip.Prefix
+
+// Token bucket of a client along with the time it was last used
+type clientBucket struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// Rate limiter keeping a token bucket for every client IP
+type clientLimiter struct {
+	mu        sync.Mutex
+	limit     rate.Limit
+	burst     int
+	clients   map[string]*clientBucket
+	lastSweep time.Time
+}
+
+// Function to create a limiter allowing each client limit requests per
+// second with bursts of up to burst requests
+func newClientLimiter(limit float64, burst int) *clientLimiter {
+	return &clientLimiter{
+		limit:   rate.Limit(limit),
+		burst:   burst,
+		clients: make(map[string]*clientBucket),
+	}
+}
+
+// Function to take a token for the client, returning how long it has to wait
+// for one when the bucket is empty
+func (l *clientLimiter) reserve(client string, now time.Time) time.Duration {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Forget the idle clients now and then so that the map stays bounded
+	if now.Sub(l.lastSweep) > rateLimitIdle {
+		for key, bucket := range l.clients {
+			if now.Sub(bucket.lastSeen) > rateLimitIdle {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	bucket, ok := l.clients[client]
+	if !ok {
+		bucket = &clientBucket{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[client] = bucket
+	}
+	bucket.lastSeen = now
+
+	reservation := bucket.limiter.ReserveN(now, 1)
+	if !reservation.OK() {
+		return rateLimitIdle
+	}
+	delay := reservation.DelayFrom(now)
+	if delay > 0 {
+		// Rejected requests do not use up the tokens of later ones
+		reservation.CancelAt(now)
+	}
+	return delay
+}
+
+// Function to parse the list of trusted proxies, single IPs are taken as
+// ranges of one address
+func parseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(entry); err == nil {
+			prefixes = append(prefixes, netip.PrefixFrom(addr.Unmap(), addr.Unmap().BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy %q", entry)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// Function to check whether the address belongs to a trusted proxy
+func isTrustedProxy(value string) bool {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// Function to get the IP of the client, which the limits are kept by. Behind
+// trusted proxies it is the last address in X-Forwarded-For that is not one
+// of them, as the earlier ones are up to the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !isTrustedProxy(host) {
+		return host
+	}
+
+	var forwarded []string
+	for _, value := range r.Header.Values("X-Forwarded-For") {
+		for _, entry := range strings.Split(value, ",") {
+			forwarded = append(forwarded, strings.TrimSpace(entry))
+		}
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		if _, err := netip.ParseAddr(forwarded[i]); err != nil {
+			// A malformed entry cannot be told apart from a forged one
+			break
+		}
+		host = forwarded[i]
+		if !isTrustedProxy(host) {
+			break
+		}
+	}
+	return host
+}
+
+// Function to wrap a handler with the rate limit, answering 429 with the
+// seconds until the next token in Retry-After once the client exceeds it
+func withRateLimit(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if renderLimiter != nil {
+			if delay := renderLimiter.reserve(clientIP(r), time.Now()); delay > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(delay.Seconds()))))
+				http.Error(w, "Too many requests", http.StatusTooManyRequests)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	defer func(proxies []netip.Prefix) { trustedProxies = proxies }(trustedProxies)
+	var err error
+	if trustedProxies, err = parseTrustedProxies("10.0.0.0/8, 192.0.2.1"); err != nil {
+		t.Fatalf("parseTrustedProxies: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"direct", "203.0.113.7:1234", nil, "203.0.113.7"},
+		{"header from an untrusted peer", "203.0.113.7:1234", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"trusted proxy", "192.0.2.1:1234", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", "10.1.2.3:1234", []string{"198.51.100.1, 10.0.0.5"}, "198.51.100.1"},
+		{"forged entries", "192.0.2.1:1234", []string{"1.2.3.4, 198.51.100.1"}, "198.51.100.1"},
+		{"separate headers", "192.0.2.1:1234", []string{"1.2.3.4", "198.51.100.1"}, "198.51.100.1"},
+		{"malformed entry", "192.0.2.1:1234", []string{"198.51.100.1, unknown"}, "192.0.2.1"},
+		{"no header", "192.0.2.1:1234", nil, "192.0.2.1"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/map", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, value := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", value)
+		}
+		if got := clientIP(r); got != tt.want {
+			t.Errorf("%s: clientIP = %s, want %s", tt.name, got, tt.want)
+		}
+	}
+
+	if _, err := parseTrustedProxies("10.0.0.0/33"); err == nil {
+		t.Error("parseTrustedProxies accepted an invalid range")
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Upper bound of a GeoJSON fetched with geojsonUrl
+const maxRemoteGeoJSONSize = 32 << 20
+
+// Time allowed to fetch a remote GeoJSON
+const remoteGeoJSONTimeout = 10 * time.Second
+
+// Hosts that geojsonUrl may point to, configured with GEOJSON_ALLOWED_HOSTS
+// (comma separated). The service would otherwise fetch any address on behalf
+// of its clients, so remote maps are disabled while this is empty.
+var geojsonAllowedHosts map[string]bool
+
+var geojsonClient = &http.Client{
+	Timeout: remoteGeoJSONTimeout,
+	// Redirects could lead away from the allowed hosts
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// Function to parse the list of allowed hosts
+func parseAllowedHosts(value string) map[string]bool {
+	hosts := make(map[string]bool)
+	for _, host := range strings.Split(value, ",") {
+		if host = strings.ToLower(strings.TrimSpace(host)); host != "" {
+			hosts[host] = true
+		}
+	}
+	return hosts
+}
+
+// Function to validate the URL of a remote GeoJSON
+func validateGeoJSONURL(value string) error {
+	if len(geojsonAllowedHosts) == 0 {
+		return errors.New("remote maps are disabled")
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if !geojsonAllowedHosts[strings.ToLower(u.Hostname())] {
+		return fmt.Errorf("host %q is not allowed", u.Hostname())
+	}
+	return nil
+}
+
+// Function to fetch and parse a remote GeoJSON into a base map. Every
+// feature must carry a numeric id, like the bundled maps.
+func fetchGeoJSON(ctx context.Context, value string) (*baseMap, error) {
+	ctx, cancel := context.WithTimeout(ctx, remoteGeoJSONTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, value, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := geojsonClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch geojson: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch geojson: %s", resp.Status)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxRemoteGeoJSONSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read geojson: %w", err)
+	}
+	if len(data) > maxRemoteGeoJSONSize {
+		return nil, fmt.Errorf("geojson is too large (> %d bytes)", maxRemoteGeoJSONSize)
+	}
+
+	fc, err := geojson.UnmarshalFeatureCollection(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unmarshal geojson: %w", err)
+	}
+	if err := validateFeatureIDs(fc); err != nil {
+		return nil, err
+	}
+	unwrapAntimeridian(fc)
+	return &baseMap{name: "remote", features: fc, emptyFeatureIDs: removeEmptyFeatures(fc)}, nil
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	svg "github.com/ajstarks/svgo"
+	"github.com/golang/freetype"
+)
+
+// Placement of the scale bar, positioned by the left end of the bar
+type scaleBarLayout struct {
+	x, y   float64
+	length float64 // Length of the bar in pixels
+	label  string
+}
+
+// Function to lay out the scale bar. The length is measured by projecting a
+// known ground distance at the center of the map, so it follows the
+// projection and the canvas size, then rounded down to 1, 2 or 5 times a
+// power of ten. It reports false when no round distance fits.
+func newScaleBarLayout(opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) (scaleBarLayout, bool) {
+	const probeKm = 100.0
+	lon, lat := opts.center[0], opts.center[1]
+	x0, y0 := funcToScreen(lon, lat)
+	x1, y1 := funcToScreen(destinationPoint(lon, lat, probeKm, 90))
+	pixelsPerKm := math.Hypot(x1-x0, y1-y0) / probeKm
+	if pixelsPerKm <= 0 || math.IsNaN(pixelsPerKm) || math.IsInf(pixelsPerKm, 0) {
+		return scaleBarLayout{}, false
+	}
+
+	maxLength := 0.2 * float64(opts.width)
+	maxKm := maxLength / pixelsPerKm
+	unit := math.Pow(10, math.Floor(math.Log10(maxKm)))
+	km := unit
+	for _, step := range []float64{5, 2} {
+		if step*unit <= maxKm {
+			km = step * unit
+			break
+		}
+	}
+	if km*pixelsPerKm < 2 {
+		return scaleBarLayout{}, false
+	}
+
+	l := scaleBarLayout{length: km * pixelsPerKm}
+	if km >= 1 {
+		l.label = fmt.Sprintf("%g km", km)
+	} else {
+		l.label = fmt.Sprintf("%g m", km*1000)
+	}
+
+	// Bottom right by default, moved to the left when another overlay
+	// holds that corner. The footer runs along the bottom.
+	margin := 16 * opts.multiplier
+	l.x = opts.mapWidth() - margin - l.length
+	if opts.legend == "bottomright" || (opts.qrURL != "" && opts.qrPosition == "bottomright") {
+		l.x = margin
+	}
+	l.y = float64(opts.height) - margin - opts.footerClearance()
+	return l, true
+}
+
+// Function to get the outline of the scale bar, with ticks at both ends
+func scaleBarPath(l scaleBarLayout, multiplier float64) string {
+	tick := 6 * multiplier
+	return fmt.Sprintf("M%.1f %.1f L%.1f %.1f L%.1f %.1f L%.1f %.1f",
+		l.x, l.y-tick, l.x, l.y, l.x+l.length, l.y, l.x+l.length, l.y-tick)
+}
+
+// Function to draw the scale bar, along with its label as SVG text for the
+// vector output
+func drawScaleBar(canvas *svg.SVG, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) {
+	l, ok := newScaleBarLayout(opts, funcToScreen)
+	if !ok {
+		return
+	}
+	m := opts.multiplier
+	path := scaleBarPath(l, m)
+	canvas.Path(path, fmt.Sprintf("fill:none;stroke:#18181b;stroke-width:%.2f;stroke-linecap:square", 4*m))
+	canvas.Path(path, fmt.Sprintf("fill:none;stroke:#fafafa;stroke-width:%.2f;stroke-linecap:square", 2*m))
+
+	if opts.vector {
+		canvas.Text(int(l.x+4*m), int(l.y-6*m), l.label,
+			fmt.Sprintf("fill:#fafafa;font-family:%s;font-size:%.1fpx", opts.fontFile().family, 12*m))
+	}
+}
+
+// Function to draw the distance of the scale bar onto the rendered image
+func drawScaleBarLabel(rgba *image.RGBA, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) error {
+	l, ok := newScaleBarLayout(opts, funcToScreen)
+	if !ok {
+		return nil
+	}
+	f, err := opts.loadFont()
+	if err != nil {
+		return fmt.Errorf("failed to load font: %w", err)
+	}
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetFontSize(12 * opts.multiplier)
+
+	pt := freetype.Pt(int(l.x+4*opts.multiplier), int(l.y-6*opts.multiplier))
+	if _, err := c.DrawString(l.label, pt); err != nil {
+		return fmt.Errorf("failed to draw scale bar label: %w", err)
+	}
+	return nil
+}
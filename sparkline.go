@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	svg "github.com/ajstarks/svgo"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Longest intensity history accepted per prefecture
+const maxHistoryLength = 32
+
+// Function to draw a small line chart of the recent intensities at the center
+// of each affected prefecture. The current intensity is the last point.
+// Prefectures too small on screen to hold the chart are skipped.
+func drawSparklines(canvas *svg.SVG, features []*geojson.Feature, scaleMap map[int]int, history map[int][]int, opts renderOptions, funcToScreen func(float64, float64) (float64, float64)) {
+	width := 32 * opts.multiplier
+	height := 12 * opts.multiplier
+	strokeWidth := 1.2 * opts.multiplier
+
+	for _, feature := range features {
+		id := int(feature.Properties["id"].(float64))
+		scale, exists := scaleMap[id]
+		if !exists || scale == 0 || len(history[id]) == 0 {
+			continue
+		}
+
+		// Screen extent of the largest polygon, where the chart is placed
+		var outer [][]float64
+		for _, polygon := range featurePolygons(feature) {
+			if len(polygon) > 0 && len(polygon[0]) > len(outer) {
+				outer = polygon[0]
+			}
+		}
+		if len(outer) == 0 {
+			continue
+		}
+		minX, minY := math.Inf(1), math.Inf(1)
+		maxX, maxY := math.Inf(-1), math.Inf(-1)
+		for _, coord := range outer {
+			x, y := funcToScreen(coord[0], coord[1])
+			minX, minY = math.Min(minX, x), math.Min(minY, y)
+			maxX, maxY = math.Max(maxX, x), math.Max(maxY, y)
+		}
+		if maxX-minX < width*1.25 || maxY-minY < height*1.5 {
+			continue
+		}
+
+		cx, cy := funcToScreen(calculateCenter(outer))
+		values := append(append([]int{}, history[id]...), scale)
+
+		var path strings.Builder
+		var lastX, lastY float64
+		for i, value := range values {
+			lastX = cx - width/2 + width*float64(i)/float64(len(values)-1)
+			lastY = cy + height/2 - height*float64(value)/7
+			if i == 0 {
+				fmt.Fprintf(&path, "M%.1f %.1f", lastX, lastY)
+			} else {
+				fmt.Fprintf(&path, " L%.1f %.1f", lastX, lastY)
+			}
+		}
+
+		// Dark backing so the line stays visible on any fill
+		pad := 3 * opts.multiplier
+		canvas.Rect(int(cx-width/2-pad), int(cy-height/2-pad), int(width+2*pad), int(height+2*pad),
+			"fill:#18181b;fill-opacity:0.6;stroke:none")
+		canvas.Path(path.String(),
+			fmt.Sprintf("fill:none;stroke:#fafafa;stroke-width:%.2f;stroke-linejoin:round", strokeWidth))
+		canvas.Circle(int(lastX), int(lastY), int(math.Max(1, 1.5*opts.multiplier)), "fill:#fafafa;stroke:none")
+	}
+}
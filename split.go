@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/golang/freetype"
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Function to render the "before" and "after" intensities next to each other
+// on one canvas, sharing the same bounds. A vertical split puts before on the
+// left and after on the right, a horizontal one stacks them top to bottom.
+func renderSplitImage(fc *geojson.FeatureCollection, before, beforeHalves, after map[int]int, opts renderOptions, orientation string, minLon, minLat, maxLon, maxLat float64) (*image.RGBA, error) {
+	half := opts
+	half.locator = false
+
+	var offset image.Point
+	if orientation == "horizontal" {
+		half.height = opts.height / 2
+		offset = image.Pt(0, half.height)
+	} else {
+		half.width = opts.width / 2
+		offset = image.Pt(half.width, 0)
+	}
+	funcToScreen := opts.screenProjection(minLon, minLat, maxLon, maxLat, float64(half.width), float64(half.height))
+
+	rgba := image.NewRGBA(image.Rect(0, 0, opts.width, opts.height))
+	draw.Draw(rgba, rgba.Bounds(), image.NewUniform(opts.background), image.Point{}, draw.Src)
+
+	// The colors, uncertainty and history given with scale belong to the
+	// after side, the before side keeps them only for its own prefectures
+	beforeHalf := half
+	beforeHalf.halves = beforeHalves
+	beforeHalf.colors = keepIDs(opts.colors, before)
+	beforeHalf.uncertain = keepIDs(opts.uncertain, before)
+	beforeHalf.history = keepIDs(opts.history, before)
+
+	for i, side := range []struct {
+		scaleMap map[int]int
+		opts     renderOptions
+	}{{before, beforeHalf}, {after, half}} {
+		svgData, err := buildSVG(fc, side.scaleMap, side.opts, funcToScreen)
+		if err != nil {
+			return nil, err
+		}
+		part, err := svgToImage(svgData, side.opts, fc.Features, side.scaleMap, funcToScreen)
+		if err != nil {
+			return nil, err
+		}
+		origin := image.Pt(offset.X*i, offset.Y*i)
+		draw.Draw(rgba, part.Bounds().Add(origin), part, image.Point{}, draw.Src)
+	}
+
+	// Divider between the two halves, in the color of the borders
+	dividerColor, err := hexToRGBA(opts.strokeColor)
+	if err != nil {
+		dividerColor = color.RGBA{0xa1, 0xa1, 0xaa, 0xff}
+	}
+	thickness := int(2 * opts.multiplier)
+	if thickness < 1 {
+		thickness = 1
+	}
+	divider := image.Rect(offset.X-thickness/2, 0, offset.X-thickness/2+thickness, opts.height)
+	if orientation == "horizontal" {
+		divider = image.Rect(0, offset.Y-thickness/2, opts.width, offset.Y-thickness/2+thickness)
+	}
+	draw.Draw(rgba, divider, image.NewUniform(dividerColor), image.Point{}, draw.Src)
+
+	// Labels in the top left corner of each half, in the text color of the
+	// theme like the footer
+	f, err := loadFont(500)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load font: %w", err)
+	}
+	c := newLabelRenderer(f, rgba, opts)
+	c.SetColor(opts.footerColor)
+	c.SetFontSize(18 * opts.multiplier)
+	for i, label := range []string{"Before", "After"} {
+		pt := freetype.Pt(offset.X*i+int(16*opts.multiplier), offset.Y*i+int(32*opts.multiplier))
+		if _, err := c.DrawString(label, pt); err != nil {
+			return nil, fmt.Errorf("failed to draw split label: %w", err)
+		}
+	}
+
+	if err := decorateImage(rgba, opts); err != nil {
+		return nil, err
+	}
+	return rgba, nil
+}
+
+// Function to copy the entries of m whose ID is in the scale map
+func keepIDs[V any](m map[int]V, scaleMap map[int]int) map[int]V {
+	kept := make(map[int]V)
+	for id, value := range m {
+		if _, ok := scaleMap[id]; ok {
+			kept[id] = value
+		}
+	}
+	return kept
+}
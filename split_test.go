@@ -0,0 +1,78 @@
+package main
+
+import (
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// A color given with scale only shows on the after side when the before
+// side does not have the prefecture
+func TestSplitBeforeColors(t *testing.T) {
+	defer func(c *renderCache) { renders = c }(renders)
+	renders = newRenderCache(0)
+
+	query := url.Values{
+		"map":    {"kanto"},
+		"scale":  {`[{"id": 13, "scale": 5, "color": "#00ff00"}]`},
+		"before": {`[{"id": 14, "scale": 3}]`},
+		"split":  {"vertical"},
+	}
+	rec := httptest.NewRecorder()
+	mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+query.Encode(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", rec.Code, http.StatusOK, rec.Body.String())
+	}
+	img, err := png.Decode(rec.Body)
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+
+	// The override is blended with the fill opacity, so match it loosely
+	bounds := img.Bounds()
+	var green [2]int
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r, g, b, _ := img.At(x, y).RGBA()
+			if g>>8 > 150 && r>>8 < 80 && b>>8 < 80 {
+				green[x*2/bounds.Dx()]++
+			}
+		}
+	}
+	if green[0] != 0 {
+		t.Errorf("before side has %d pixels in the override color, want none", green[0])
+	}
+	if green[1] == 0 {
+		t.Error("after side has no pixels in the override color")
+	}
+}
+
+func TestSplitRejectedCombinations(t *testing.T) {
+	tests := []struct {
+		param, value string
+	}{
+		{"format", "svg"},
+		{"format", "symbol"},
+		{"format", "pdf"},
+		{"format", "gif"},
+		{"format", "json"},
+		{"format", "mask"},
+		{"format", "topojson"},
+		{"locator", "true"},
+	}
+	for _, tt := range tests {
+		query := url.Values{
+			"map":    {"kanto"},
+			"scale":  {`[{"id": 13, "scale": 5}]`},
+			"before": {`[{"id": 14, "scale": 3}]`},
+			tt.param: {tt.value},
+		}
+		rec := httptest.NewRecorder()
+		mapHandler(rec, httptest.NewRequest(http.MethodGet, "/map?"+query.Encode(), nil))
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s=%s: status %d, want %d", tt.param, tt.value, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
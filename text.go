@@ -0,0 +1,128 @@
+package main
+
+import (
+	"image"
+	"image/color"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+// Text drawing on top of the rasterized map
+type textRenderer struct {
+	c      *freetype.Context
+	dst    *image.RGBA
+	src    color.Color
+	size   float64 // Font size in points
+	dpi    float64
+	smooth bool
+	mask   *image.Alpha // Coverage buffer used when smoothing is disabled
+
+	halo      color.Color // Outline drawn behind the text, nil for none
+	haloWidth float64     // Outline width in pixels
+}
+
+func newTextRenderer(f *truetype.Font, dst *image.RGBA, smooth bool) *textRenderer {
+	c := freetype.NewContext()
+	c.SetDPI(72)
+	c.SetFont(f)
+	c.SetClip(dst.Bounds())
+	c.SetDst(dst)
+
+	t := &textRenderer{c: c, dst: dst, smooth: smooth, dpi: 72}
+	if !smooth {
+		// Hinting keeps the glyphs aligned to the pixel grid, which matters
+		// more once the edges are no longer anti-aliased
+		c.SetHinting(font.HintingFull)
+		t.mask = image.NewAlpha(dst.Bounds())
+		c.SetDst(t.mask)
+		c.SetSrc(image.Opaque)
+	}
+	t.SetFontSize(14)
+	t.SetColor(color.RGBA{0xfa, 0xfa, 0xfa, 0xff})
+	return t
+}
+
+// Function to create a text renderer for map labels, applying the text
+// options of the request
+func newLabelRenderer(f *truetype.Font, dst *image.RGBA, opts renderOptions) *textRenderer {
+	t := newTextRenderer(f, dst, opts.textSmooth)
+	if opts.labelHalo {
+		t.SetHalo(opts.labelHaloColor, max(1, 1.5*opts.multiplier))
+	}
+	return t
+}
+
+// SetFontSize sets the font size in points (at 72 DPI, also pixels)
+func (t *textRenderer) SetFontSize(size float64) {
+	t.size = size
+	t.c.SetFontSize(size)
+}
+
+// SetDPI sets the resolution the font size is converted to pixels at
+func (t *textRenderer) SetDPI(dpi float64) {
+	t.dpi = dpi
+	t.c.SetDPI(dpi)
+}
+
+// SetColor sets the color used for the following strings
+func (t *textRenderer) SetColor(c color.Color) {
+	t.src = c
+}
+
+// SetHalo sets the color and width of the outline drawn behind the following
+// strings, a nil color disables it
+func (t *textRenderer) SetHalo(c color.Color, width float64) {
+	t.halo = c
+	t.haloWidth = width
+}
+
+// DrawString draws the string with its baseline starting at pt
+func (t *textRenderer) DrawString(s string, pt fixed.Point26_6) (fixed.Point26_6, error) {
+	if t.halo != nil {
+		// The text is repeated around its position in the halo color, the
+		// diagonals are shortened so the outline stays round
+		r := t.haloWidth * 64
+		d := r * 0.7071
+		offsets := [][2]float64{{-r, 0}, {r, 0}, {0, -r}, {0, r}, {-d, -d}, {d, -d}, {-d, d}, {d, d}}
+		for _, o := range offsets {
+			offset := fixed.Point26_6{X: fixed.Int26_6(o[0]), Y: fixed.Int26_6(o[1])}
+			if _, err := t.drawString(s, pt.Add(offset), t.halo); err != nil {
+				return pt, err
+			}
+		}
+	}
+	return t.drawString(s, pt, t.src)
+}
+
+// Function to draw a single pass of the string in the given color
+func (t *textRenderer) drawString(s string, pt fixed.Point26_6, src color.Color) (fixed.Point26_6, error) {
+	if t.smooth {
+		t.c.SetSrc(image.NewUniform(src))
+		return t.c.DrawString(s, pt)
+	}
+
+	end, err := t.c.DrawString(s, pt)
+	if err != nil {
+		return end, err
+	}
+
+	// Threshold the coverage of the glyphs around the baseline
+	size := int(t.size*t.dpi/72) + 1
+	rect := image.Rect(pt.X.Floor()-1, pt.Y.Floor()-2*size, end.X.Ceil()+1, pt.Y.Floor()+size).Intersect(t.mask.Bounds())
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			coverage := t.mask.AlphaAt(x, y).A
+			if coverage == 0 {
+				continue
+			}
+			if coverage >= 0x80 {
+				blendPixel(t.dst, x, y, src)
+			}
+			t.mask.SetAlpha(x, y, color.Alpha{})
+		}
+	}
+	return end, nil
+}
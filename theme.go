@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image/color"
+	"net/url"
+	"strconv"
+)
+
+// Theme used when the request does not select one
+const defaultTheme = "dark"
+
+// Colors of the map around the intensities, selected with the theme
+// parameter. The parameters setting a single color take precedence. The
+// overlays (legend, labels, etc.) bring their own contrast and keep their
+// colors.
+type theme struct {
+	background  color.RGBA
+	land        string     // Fill of the prefectures without intensity (#rrggbb)
+	border      string     // Color of the prefecture borders (#rrggbb)
+	text        color.RGBA // Color of the footer text
+	fillOpacity float64    // Opacity of the intensity fills
+}
+
+// Selectable themes. The light theme draws the fills opaque, since blending
+// them with a light background washes out the lower intensities.
+var themes = map[string]theme{
+	"dark": {
+		background:  color.RGBA{0x18, 0x18, 0x1b, 0xff},
+		land:        "#27272a",
+		border:      "#a1a1aa",
+		text:        color.RGBA{0xfa, 0xfa, 0xfa, 0xff},
+		fillOpacity: 0.8,
+	},
+	"light": {
+		background:  color.RGBA{0xfa, 0xfa, 0xfa, 0xff},
+		land:        "#e4e4e7",
+		border:      "#52525b",
+		text:        color.RGBA{0x18, 0x18, 0x1b, 0xff},
+		fillOpacity: 1,
+	},
+}
+
+// Function to fill the prefectures without intensity with the land color of
+// the theme instead of the neutral color of the palette
+func (t theme) landPalette(colors palette) palette {
+	return func(scale int) string {
+		if scale <= 0 {
+			return t.land
+		}
+		return colors(scale)
+	}
+}
+
+// Function to get the theme selected with the theme parameter, with the
+// border and the fill opacity set by the strokeColor and fillOpacity
+// parameters when given. On failure it returns the invalid parameter.
+func parseTheme(query url.Values) (theme, string, bool) {
+	th := themes[defaultTheme]
+	if name := query.Get("theme"); name != "" {
+		var ok bool
+		if th, ok = themes[name]; !ok {
+			return th, "theme", false
+		}
+	}
+
+	if value := query.Get("fillOpacity"); value != "" {
+		fillOpacity, err := strconv.ParseFloat(value, 64)
+		if err != nil || fillOpacity < 0 || fillOpacity > 1 {
+			return th, "fillOpacity", false
+		}
+		th.fillOpacity = fillOpacity
+	}
+
+	if value := query.Get("strokeColor"); value != "" {
+		if _, err := hexToRGBA(value); err != nil {
+			return th, "strokeColor", false
+		}
+		th.border = value
+	}
+	return th, "", true
+}
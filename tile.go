@@ -0,0 +1,339 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Size of a map tile in pixels
+const tileSize = 256
+
+// Highest zoom level served, deeper tiles add no detail to the prefectures
+const maxTileZoom = 14
+
+// Share of a tile added around it when clipping, so that the borders cut
+// by the clipping stay outside of the tile
+const tileClipPadding = 1.0 / 16
+
+// Function to get the bounds of a Web Mercator tile in degrees
+func tileBounds(z, x, y int) (minLon, minLat, maxLon, maxLat float64) {
+	n := float64(int(1) << z)
+	lat := func(y float64) float64 {
+		return math.Atan(math.Sinh(math.Pi*(1-2*y/n))) * 180 / math.Pi
+	}
+	return float64(x)/n*360 - 180, lat(float64(y + 1)), float64(x+1)/n*360 - 180, lat(float64(y))
+}
+
+// Function to create the projector of a Web Mercator tile, mapping the
+// tile onto a canvas of tileSize pixels
+func tileProjection(z, x, y int) Projector {
+	n := float64(int(1) << z)
+	return func(lon, lat float64) (float64, float64) {
+		phi := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, lat)) * math.Pi / 180
+		worldX := (lon + 180) / 360 * n
+		worldY := (1 - math.Log(math.Tan(phi)+1/math.Cos(phi))/math.Pi) / 2 * n
+		return (worldX - float64(x)) * tileSize, (worldY - float64(y)) * tileSize
+	}
+}
+
+// Function to clip a ring to the bounds with the Sutherland-Hodgman
+// algorithm. Parts outside of the bounds collapse onto their edges, which is
+// fine for fills as long as the edges are outside of the drawn area.
+func clipRing(ring [][]float64, minLon, minLat, maxLon, maxLat float64) [][]float64 {
+	edges := []struct {
+		inside    func(p []float64) bool
+		intersect func(a, b []float64) []float64
+	}{
+		{func(p []float64) bool { return p[0] >= minLon }, func(a, b []float64) []float64 { return atLon(a, b, minLon) }},
+		{func(p []float64) bool { return p[0] <= maxLon }, func(a, b []float64) []float64 { return atLon(a, b, maxLon) }},
+		{func(p []float64) bool { return p[1] >= minLat }, func(a, b []float64) []float64 { return atLat(a, b, minLat) }},
+		{func(p []float64) bool { return p[1] <= maxLat }, func(a, b []float64) []float64 { return atLat(a, b, maxLat) }},
+	}
+
+	clipped := ring
+	for _, edge := range edges {
+		if len(clipped) == 0 {
+			break
+		}
+		input := clipped
+		clipped = nil
+		prev := input[len(input)-1]
+		for _, p := range input {
+			switch {
+			case edge.inside(p) && !edge.inside(prev):
+				clipped = append(clipped, edge.intersect(prev, p), p)
+			case edge.inside(p):
+				clipped = append(clipped, p)
+			case edge.inside(prev):
+				clipped = append(clipped, edge.intersect(prev, p))
+			}
+			prev = p
+		}
+	}
+	if len(clipped) < 3 {
+		return nil
+	}
+	return clipped
+}
+
+// Function to get the point of the segment at the longitude
+func atLon(a, b []float64, lon float64) []float64 {
+	t := (lon - a[0]) / (b[0] - a[0])
+	return []float64{lon, a[1] + (b[1]-a[1])*t}
+}
+
+// Function to get the point of the segment at the latitude
+func atLat(a, b []float64, lat float64) []float64 {
+	t := (lat - a[1]) / (b[1] - a[1])
+	return []float64{a[0] + (b[0]-a[0])*t, lat}
+}
+
+// Function to clip the features to the bounds, leaving out those outside of
+// them. The clipped features keep the properties of the originals.
+func clipFeatures(features []*geojson.Feature, minLon, minLat, maxLon, maxLat float64) []*geojson.Feature {
+	var clipped []*geojson.Feature
+	for _, feature := range features {
+		var polygons [][][][]float64
+		for _, polygon := range featurePolygons(feature) {
+			var rings [][][]float64
+			for i, ring := range polygon {
+				c := clipRing(ring, minLon, minLat, maxLon, maxLat)
+				if c == nil {
+					if i == 0 {
+						// The holes cannot show without the outer ring
+						break
+					}
+					continue
+				}
+				rings = append(rings, c)
+			}
+			if len(rings) > 0 {
+				polygons = append(polygons, rings)
+			}
+		}
+		if len(polygons) == 0 {
+			continue
+		}
+		c := geojson.NewMultiPolygonFeature(polygons...)
+		c.Properties = feature.Properties
+		clipped = append(clipped, c)
+	}
+	return clipped
+}
+
+// Function to move the features along the longitude, the coordinates are
+// copied since the clipped features share them with the map
+func shiftFeatures(features []*geojson.Feature, offset float64) []*geojson.Feature {
+	if offset == 0 {
+		return features
+	}
+	for _, feature := range features {
+		for _, polygon := range feature.Geometry.MultiPolygon {
+			for _, ring := range polygon {
+				for i, coord := range ring {
+					ring[i] = []float64{coord[0] + offset, coord[1]}
+				}
+			}
+		}
+	}
+	return features
+}
+
+// Function to clip the features to a tile and the padding around it. Maps
+// unwrapped past the antimeridian reach into the tiles on the other side of
+// the globe, those parts are clipped a full turn away and moved back into
+// the range of the tiles.
+func tileFeatures(features []*geojson.Feature, z, x, y int) []*geojson.Feature {
+	minLon, minLat, maxLon, maxLat := tileBounds(z, x, y)
+	padLon, padLat := (maxLon-minLon)*tileClipPadding, (maxLat-minLat)*tileClipPadding
+	var clipped []*geojson.Feature
+	for _, turn := range []float64{0, 360, -360} {
+		c := clipFeatures(features, minLon-padLon+turn, minLat-padLat, maxLon+padLon+turn, maxLat+padLat)
+		clipped = append(clipped, shiftFeatures(c, -turn)...)
+	}
+	return clipped
+}
+
+// Function to parse the coordinates of a tile path (/tile/{z}/{x}/{y}.png)
+func parseTileCoordinates(r *http.Request) (z, x, y int, err error) {
+	yValue, found := strings.CutSuffix(r.PathValue("y"), ".png")
+	if !found {
+		return 0, 0, 0, errors.New("tiles are only served as png")
+	}
+	var errZ, errX, errY error
+	z, errZ = strconv.Atoi(r.PathValue("z"))
+	x, errX = strconv.Atoi(r.PathValue("x"))
+	y, errY = strconv.Atoi(yValue)
+	if errZ != nil || errX != nil || errY != nil {
+		return 0, 0, 0, errors.New("coordinates must be integers")
+	}
+	if z < 0 || z > maxTileZoom {
+		return 0, 0, 0, fmt.Errorf("zoom must be between 0 and %d", maxTileZoom)
+	}
+	if x < 0 || y < 0 || x >= 1<<z || y >= 1<<z {
+		return 0, 0, 0, fmt.Errorf("tile %d/%d is outside of zoom level %d", x, y, z)
+	}
+	return z, x, y, nil
+}
+
+// Renders a Web Mercator tile of the intensities for slippy map viewers such
+// as Leaflet. Only the affected prefectures are drawn onto a transparent
+// background so that the tiles can be layered over a base map.
+func tileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", "GET")
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	z, x, y, err := parseTileCoordinates(r)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid tile: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	scaleData := r.URL.Query().Get("scale")
+	if scaleData == "" {
+		http.Error(w, "scale parameter is required", http.StatusBadRequest)
+		return
+	}
+	intensities, err := parseIntensities(scaleData)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid scale data format: %v", err), http.StatusBadRequest)
+		return
+	}
+	dedupe := r.URL.Query().Get("dedupe")
+	if dedupe == "" {
+		dedupe = defaultDedupeMode
+	}
+	if !isDedupeMode(dedupe) {
+		http.Error(w, fmt.Sprintf("Invalid dedupe value: %s", dedupe), http.StatusBadRequest)
+		return
+	}
+	if intensities, err = dedupeIntensities(intensities, dedupe); err != nil {
+		http.Error(w, fmt.Sprintf("Conflicting scale data: %v", err), http.StatusBadRequest)
+		return
+	}
+	logScaleCount(r, len(intensities))
+
+	etag := renderETag(r, intensities)
+	if etag != "" && etagMatches(r.Header.Get("If-None-Match"), etag) {
+		setCacheHeaders(w, etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if renders.serve(w, etag) {
+		return
+	}
+	var storeRender func()
+	w, storeRender = renders.record(w, etag)
+	defer storeRender()
+
+	base, ok := selectBaseMap(r.URL.Query().Get("map"))
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid map value: %s", r.URL.Query().Get("map")), http.StatusBadRequest)
+		return
+	}
+
+	colors := palettes["jma"]
+	if name := r.URL.Query().Get("palette"); name != "" {
+		if colors, ok = palettes[name]; !ok {
+			http.Error(w, fmt.Sprintf("Invalid palette value: %s", name), http.StatusBadRequest)
+			return
+		}
+	}
+	th, param, ok := parseTheme(r.URL.Query())
+	if !ok {
+		http.Error(w, fmt.Sprintf("Invalid %s value: %s", param, r.URL.Query().Get(param)), http.StatusBadRequest)
+		return
+	}
+	colors = th.landPalette(colors)
+
+	scaleMap := make(map[int]int)
+	halves := make(map[int]int)
+	fills := make(map[int]string)
+	for _, intensity := range intensities {
+		if intensity.Scale < 0 || intensity.Scale > 7 {
+			http.Error(w, fmt.Sprintf("Invalid scale value for ID %d: %d",
+				intensity.ID, intensity.Scale), http.StatusBadRequest)
+			return
+		}
+		if !base.hasFeatureID(intensity.ID) {
+			http.Error(w, fmt.Sprintf("ID %d does not match any feature of the map", intensity.ID), http.StatusBadRequest)
+			return
+		}
+		if intensity.Color != "" {
+			if _, err := hexToRGBA(intensity.Color); err != nil {
+				http.Error(w, fmt.Sprintf("Invalid color value for ID %d: %s", intensity.ID, intensity.Color), http.StatusBadRequest)
+				return
+			}
+			fills[intensity.ID] = intensity.Color
+		}
+		scaleMap[intensity.ID] = intensity.Scale
+		if intensity.half != 0 {
+			halves[intensity.ID] = intensity.half
+		}
+	}
+
+	// Only the affected prefectures reaching into the tile are drawn.
+	// affectedFeatures falls back to the whole map, which is left out here.
+	affected := affectedFeatures(base.features, scaleMap)
+	if affected == base.features {
+		affected = geojson.NewFeatureCollection()
+	}
+	features := tileFeatures(affected.Features, z, x, y)
+
+	opts := renderOptions{
+		width:       tileSize,
+		height:      tileSize,
+		multiplier:  1,
+		smooth:      true,
+		textSmooth:  true,
+		strokeMin:   0.25,
+		strokeMax:   4,
+		palette:     colors,
+		halves:      halves,
+		supersample: 1,
+		borderWidth: -1,
+		strokeColor: th.border,
+		fillOpacity: th.fillOpacity,
+		colors:      fills,
+	}
+
+	// A tile without affected prefectures stays blank
+	rgba := image.NewRGBA(image.Rect(0, 0, tileSize, tileSize))
+	if len(features) > 0 {
+		funcToScreen := tileProjection(z, x, y)
+		fc := &geojson.FeatureCollection{Features: features}
+		svgData, err := buildSVG(fc, scaleMap, opts, funcToScreen)
+		if err == nil {
+			rgba, err = svgToImage(svgData, opts, features, scaleMap, funcToScreen)
+		}
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to render tile: %v", err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	pngData, err := encodePNG(r.Context(), rgba)
+	if errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		http.Error(w, "Timed out while encoding png", http.StatusServiceUnavailable)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to encode png: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/png")
+	setCacheHeaders(w, etag)
+	w.Write(pngData)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// A prefecture unwrapped past the antimeridian shows on the tiles of both
+// sides of the globe
+func TestTileFeaturesAntimeridian(t *testing.T) {
+	feature := geojson.NewPolygonFeature([][][]float64{{{175, 10}, {185, 10}, {185, 20}, {175, 20}, {175, 10}}})
+	feature.Properties["id"] = float64(1)
+
+	tests := []struct {
+		x              int
+		minLon, maxLon float64
+	}{
+		{0, -185, -175},
+		{1, 175, 185},
+	}
+	for _, tt := range tests {
+		features := tileFeatures([]*geojson.Feature{feature}, 1, tt.x, 0)
+		if len(features) != 1 {
+			t.Fatalf("tile %d: %d features, want 1", tt.x, len(features))
+		}
+		for _, coord := range featureRings(features[0])[0] {
+			if coord[0] < tt.minLon-1e-9 || coord[0] > tt.maxLon+1e-9 {
+				t.Errorf("tile %d: longitude %g outside of %g..%g", tt.x, coord[0], tt.minLon, tt.maxLon)
+			}
+		}
+	}
+
+	// The map itself is left as it was
+	if got := featureRings(feature)[0][1][0]; got != 185 {
+		t.Errorf("the clipping moved the map to %g, want 185", got)
+	}
+}
+
+func TestTileTheme(t *testing.T) {
+	defer func(c *renderCache) { renders = c }(renders)
+	renders = newRenderCache(0)
+
+	tile := func(params url.Values) *httptest.ResponseRecorder {
+		params.Set("scale", `[{"id": 13, "scale": 5}]`)
+		req := httptest.NewRequest(http.MethodGet, "/tile/5/28/12.png?"+params.Encode(), nil)
+		req.SetPathValue("z", "5")
+		req.SetPathValue("x", "28")
+		req.SetPathValue("y", "12.png")
+		rec := httptest.NewRecorder()
+		tileHandler(rec, req)
+		return rec
+	}
+
+	base := tile(url.Values{})
+	if base.Code != http.StatusOK {
+		t.Fatalf("status %d, want %d: %s", base.Code, http.StatusOK, base.Body.String())
+	}
+	for _, params := range []url.Values{
+		{"theme": {"light"}},
+		{"strokeColor": {"#ff0000"}},
+		{"fillOpacity": {"0.3"}},
+	} {
+		rec := tile(params)
+		if rec.Code != http.StatusOK {
+			t.Errorf("%v: status %d, want %d: %s", params, rec.Code, http.StatusOK, rec.Body.String())
+			continue
+		}
+		if bytes.Equal(rec.Body.Bytes(), base.Body.Bytes()) {
+			t.Errorf("%v: tile is the same as without the parameter", params)
+		}
+	}
+
+	for _, params := range []url.Values{
+		{"theme": {"sepia"}},
+		{"strokeColor": {"red"}},
+		{"fillOpacity": {"2"}},
+	} {
+		if rec := tile(params); rec.Code != http.StatusBadRequest {
+			t.Errorf("%v: status %d, want %d", params, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
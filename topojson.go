@@ -0,0 +1,333 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	geojson "github.com/paulmach/go.geojson"
+)
+
+// Number of quantization steps used for the TopoJSON coordinates
+const topoQuantization = 100000
+
+type Topology struct {
+	Type      string                    `json:"type"`
+	BBox      [4]float64                `json:"bbox"`
+	Transform TopoTransform             `json:"transform"`
+	Objects   map[string]TopoCollection `json:"objects"`
+	Arcs      [][][2]int                `json:"arcs"`
+}
+
+type TopoTransform struct {
+	Scale     [2]float64 `json:"scale"`
+	Translate [2]float64 `json:"translate"`
+}
+
+type TopoCollection struct {
+	Type       string         `json:"type"`
+	Geometries []TopoGeometry `json:"geometries"`
+}
+
+type TopoGeometry struct {
+	Type       string                 `json:"type"`
+	Arcs       interface{}            `json:"arcs"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+type topoPoint [2]int
+
+// Function to return a copy of the topology with the intensity of each
+// prefecture injected into its properties, along with its label and color
+// as drawn on the map, which follow the halves of 5 and 6 and the colors
+// given per prefecture
+func topologyWithScale(topo *Topology, name string, scaleMap map[int]int, opts renderOptions) *Topology {
+	geometries := make([]TopoGeometry, 0, len(topo.Objects[name].Geometries))
+	for _, geometry := range topo.Objects[name].Geometries {
+		properties := make(map[string]interface{}, len(geometry.Properties)+2)
+		for k, v := range geometry.Properties {
+			properties[k] = v
+		}
+
+		id, scale := 0, 0
+		if value, ok := properties["id"].(float64); ok {
+			id = int(value)
+			scale = scaleMap[id]
+		}
+		properties["scale"] = scale
+		properties["label"] = scaleText(scale, opts.halves[id])
+		properties["color"] = opts.fillColor(id, scale)
+
+		geometry.Properties = properties
+		geometries = append(geometries, geometry)
+	}
+
+	result := *topo
+	result.Objects = map[string]TopoCollection{
+		name: {Type: "GeometryCollection", Geometries: geometries},
+	}
+	return &result
+}
+
+// Function to convert a FeatureCollection into a quantized topology where the
+// borders shared by adjacent prefectures are stored only once. The
+// prefectures are stored as the object of the given name.
+func buildTopology(fc *geojson.FeatureCollection, name string) *Topology {
+	minLon, minLat := 180.0, 90.0
+	maxLon, maxLat := -180.0, -90.0
+	for _, feature := range fc.Features {
+		for _, ring := range featureRings(feature) {
+			for _, coord := range ring {
+				minLon = min(minLon, coord[0])
+				minLat = min(minLat, coord[1])
+				maxLon = max(maxLon, coord[0])
+				maxLat = max(maxLat, coord[1])
+			}
+		}
+	}
+
+	kx := (maxLon - minLon) / (topoQuantization - 1)
+	ky := (maxLat - minLat) / (topoQuantization - 1)
+	if kx == 0 {
+		kx = 1
+	}
+	if ky == 0 {
+		ky = 1
+	}
+
+	quantize := func(ring [][]float64) []topoPoint {
+		points := make([]topoPoint, 0, len(ring)+1)
+		for _, coord := range ring {
+			p := topoPoint{int((coord[0]-minLon)/kx + 0.5), int((coord[1]-minLat)/ky + 0.5)}
+			// Drop points that collapse onto the previous one
+			if len(points) > 0 && points[len(points)-1] == p {
+				continue
+			}
+			points = append(points, p)
+		}
+		// Make sure the ring is closed
+		if len(points) > 0 && points[0] != points[len(points)-1] {
+			points = append(points, points[0])
+		}
+		return points
+	}
+
+	// Quantize every polygon of every feature
+	quantized := make([][][][]topoPoint, len(fc.Features))
+	for i, feature := range fc.Features {
+		for _, polygon := range featurePolygons(feature) {
+			var rings [][]topoPoint
+			for _, ring := range polygon {
+				if points := quantize(ring); len(points) >= 4 {
+					rings = append(rings, points)
+				}
+			}
+			if len(rings) > 0 {
+				quantized[i] = append(quantized[i], rings)
+			}
+		}
+	}
+
+	junctions := findJunctions(quantized)
+
+	topo := &Topology{
+		Type: "Topology",
+		BBox: [4]float64{minLon, minLat, maxLon, maxLat},
+		Transform: TopoTransform{
+			Scale:     [2]float64{kx, ky},
+			Translate: [2]float64{minLon, minLat},
+		},
+	}
+
+	arcIndex := make(map[string]int)
+	var arcs [][]topoPoint
+	addArc := func(arc []topoPoint) int {
+		if i, ok := arcIndex[arcKey(arc)]; ok {
+			return i
+		}
+		reversed := make([]topoPoint, len(arc))
+		for i, p := range arc {
+			reversed[len(arc)-1-i] = p
+		}
+		if i, ok := arcIndex[arcKey(reversed)]; ok {
+			// A negative index refers to the arc in reverse order
+			return ^i
+		}
+		arcIndex[arcKey(arc)] = len(arcs)
+		arcs = append(arcs, arc)
+		return len(arcs) - 1
+	}
+
+	var geometries []TopoGeometry
+	for i, feature := range fc.Features {
+		var polygons [][][]int
+		for _, polygon := range quantized[i] {
+			var rings [][]int
+			for _, ring := range polygon {
+				var indices []int
+				for _, arc := range cutRing(ring, junctions) {
+					indices = append(indices, addArc(arc))
+				}
+				rings = append(rings, indices)
+			}
+			polygons = append(polygons, rings)
+		}
+
+		properties := make(map[string]interface{}, len(feature.Properties))
+		for k, v := range feature.Properties {
+			properties[k] = v
+		}
+
+		geometry := TopoGeometry{Properties: properties}
+		switch {
+		case len(polygons) == 0:
+			geometry.Type = "Polygon"
+			geometry.Arcs = [][]int{}
+		case feature.Geometry.Type == "Polygon":
+			geometry.Type = "Polygon"
+			geometry.Arcs = polygons[0]
+		default:
+			geometry.Type = "MultiPolygon"
+			geometry.Arcs = polygons
+		}
+		geometries = append(geometries, geometry)
+	}
+
+	// Delta-encode the arcs
+	topo.Arcs = make([][][2]int, len(arcs))
+	for i, arc := range arcs {
+		encoded := make([][2]int, len(arc))
+		prev := topoPoint{0, 0}
+		for j, p := range arc {
+			encoded[j] = [2]int{p[0] - prev[0], p[1] - prev[1]}
+			prev = p
+		}
+		topo.Arcs[i] = encoded
+	}
+
+	topo.Objects = map[string]TopoCollection{
+		name: {Type: "GeometryCollection", Geometries: geometries},
+	}
+	return topo
+}
+
+// Function to find the points where a border splits, i.e. points that are
+// visited with different neighbors by different rings
+func findJunctions(polygons [][][][]topoPoint) map[topoPoint]bool {
+	type neighbors [2]topoPoint
+	seen := make(map[topoPoint]neighbors)
+	junctions := make(map[topoPoint]bool)
+
+	for _, feature := range polygons {
+		for _, polygon := range feature {
+			for _, ring := range polygon {
+				n := len(ring) - 1 // The last point repeats the first one
+				for i := 0; i < n; i++ {
+					p := ring[i]
+					prev := ring[(i-1+n)%n]
+					next := ring[(i+1)%n]
+					// The same border is walked in the opposite direction by the neighbor
+					if prev[0] > next[0] || (prev[0] == next[0] && prev[1] > next[1]) {
+						prev, next = next, prev
+					}
+
+					if existing, ok := seen[p]; ok {
+						if existing != (neighbors{prev, next}) {
+							junctions[p] = true
+						}
+						continue
+					}
+					seen[p] = neighbors{prev, next}
+				}
+			}
+		}
+	}
+	return junctions
+}
+
+// Function to split a closed ring into arcs at the junction points
+func cutRing(ring []topoPoint, junctions map[topoPoint]bool) [][]topoPoint {
+	n := len(ring) - 1
+
+	start := -1
+	for i := 0; i < n; i++ {
+		if junctions[ring[i]] {
+			start = i
+			break
+		}
+	}
+
+	if start == -1 {
+		// No junction, rotate the ring so identical rings share the same start
+		start = 0
+		for i := 1; i < n; i++ {
+			if ring[i][0] < ring[start][0] || (ring[i][0] == ring[start][0] && ring[i][1] < ring[start][1]) {
+				start = i
+			}
+		}
+		rotated := make([]topoPoint, 0, n+1)
+		for i := 0; i <= n; i++ {
+			rotated = append(rotated, ring[(start+i)%n])
+		}
+		return [][]topoPoint{rotated}
+	}
+
+	var arcs [][]topoPoint
+	arc := []topoPoint{ring[start]}
+	for i := 1; i <= n; i++ {
+		p := ring[(start+i)%n]
+		arc = append(arc, p)
+		if junctions[p] {
+			arcs = append(arcs, arc)
+			arc = []topoPoint{p}
+		}
+	}
+	return arcs
+}
+
+// Function to build a lookup key for an arc
+func arcKey(arc []topoPoint) string {
+	var sb strings.Builder
+	for _, p := range arc {
+		fmt.Fprintf(&sb, "%d,%d;", p[0], p[1])
+	}
+	return sb.String()
+}
+
+// Function to get the polygons of a feature as a list of rings
+func featurePolygons(feature *geojson.Feature) [][][][]float64 {
+	return geometryPolygons(feature.Geometry, nil)
+}
+
+// Function to get the polygons of a geometry, recursing into geometry
+// collections. Geometries without an area (points, lines) are passed to
+// skipped, which may be nil.
+func geometryPolygons(geometry *geojson.Geometry, skipped func(geometryType string)) [][][][]float64 {
+	if geometry == nil {
+		return nil
+	}
+	switch geometry.Type {
+	case "Polygon":
+		return [][][][]float64{geometry.Polygon}
+	case "MultiPolygon":
+		return geometry.MultiPolygon
+	case "GeometryCollection":
+		var polygons [][][][]float64
+		for _, g := range geometry.Geometries {
+			polygons = append(polygons, geometryPolygons(g, skipped)...)
+		}
+		return polygons
+	}
+	if skipped != nil {
+		skipped(string(geometry.Type))
+	}
+	return nil
+}
+
+// Function to get all rings of a feature
+func featureRings(feature *geojson.Feature) [][][]float64 {
+	var rings [][][]float64
+	for _, polygon := range featurePolygons(feature) {
+		rings = append(rings, polygon...)
+	}
+	return rings
+}
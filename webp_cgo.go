@@ -0,0 +1,22 @@
+//go:build cgo
+
+package main
+
+import (
+	"context"
+	"image"
+	"io"
+
+	"github.com/chai2010/webp"
+)
+
+// The WebP encoder wraps libwebp and is only built with cgo
+const webpSupported = true
+
+// Function to encode the image as WebP, either lossless or lossy with the
+// given quality (1-100)
+func encodeWebP(ctx context.Context, img image.Image, lossless bool, quality int) ([]byte, error) {
+	return encodeWithContext(ctx, func(w io.Writer) error {
+		return webp.Encode(w, img, &webp.Options{Lossless: lossless, Quality: float32(quality)})
+	})
+}
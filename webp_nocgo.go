@@ -0,0 +1,17 @@
+//go:build !cgo
+
+package main
+
+import (
+	"context"
+	"errors"
+	"image"
+)
+
+// Builds without cgo have no WebP encoder, format=webp is rejected
+const webpSupported = false
+
+// Function standing in for the WebP encoder of cgo builds
+func encodeWebP(ctx context.Context, img image.Image, lossless bool, quality int) ([]byte, error) {
+	return nil, errors.New("webp not supported in this build")
+}